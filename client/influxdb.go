@@ -14,6 +14,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -89,6 +90,9 @@ func ParseConnectionString(path string, ssl bool) (url.URL, error) {
 // Username/Password are optional. They will be passed via basic auth if provided.
 // UserAgent: If not provided, will default "InfluxDBClient",
 // Timeout: If not provided, will default to 0 (no timeout)
+// WritePath/QueryPath: If not provided, will default to "write" and "query",
+// they are set for servers mounted under a prefix by a proxy. Bucket writes
+// go to "api/v2/write" under the same prefix as WritePath.
 type Config struct {
 	URL              url.URL
 	UnixSocket       string
@@ -99,6 +103,17 @@ type Config struct {
 	Precision        string
 	WriteConsistency string
 	UnsafeSsl        bool
+	WritePath        string
+	QueryPath        string
+
+	// ChunkedWrites sends line protocol write bodies with chunked transfer
+	// encoding instead of a Content-Length header.
+	ChunkedWrites bool
+
+	// TLSConfig, if set, configures HTTPS connections, for instance with a
+	// pool of trusted CAs or client certificates. UnsafeSsl is then ignored;
+	// set InsecureSkipVerify on TLSConfig instead.
+	TLSConfig *tls.Config
 }
 
 // NewConfig will create a config to be used in connecting to the client
@@ -117,6 +132,9 @@ type Client struct {
 	httpClient *http.Client
 	userAgent  string
 	precision  string
+	writePath  string
+	queryPath  string
+	chunked    bool
 }
 
 const (
@@ -135,8 +153,11 @@ const (
 
 // NewClient will instantiate and return a connected client to issue commands to the server.
 func NewClient(c Config) (*Client, error) {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: c.UnsafeSsl,
+	tlsConfig := c.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: c.UnsafeSsl,
+		}
 	}
 
 	tr := &http.Transport{
@@ -160,10 +181,19 @@ func NewClient(c Config) (*Client, error) {
 		httpClient: &http.Client{Timeout: c.Timeout, Transport: tr},
 		userAgent:  c.UserAgent,
 		precision:  c.Precision,
+		writePath:  c.WritePath,
+		queryPath:  c.QueryPath,
+		chunked:    c.ChunkedWrites,
 	}
 	if client.userAgent == "" {
 		client.userAgent = "InfluxDBClient"
 	}
+	if client.writePath == "" {
+		client.writePath = "write"
+	}
+	if client.queryPath == "" {
+		client.queryPath = "query"
+	}
 	return &client, nil
 }
 
@@ -182,7 +212,7 @@ func (c *Client) SetPrecision(precision string) {
 func (c *Client) Query(q Query) (*Response, error) {
 	u := c.url
 
-	u.Path = "query"
+	u.Path = c.queryPath
 	values := u.Query()
 	values.Set("q", q.Command)
 	values.Set("db", q.Database)
@@ -256,7 +286,7 @@ func (c *Client) Query(q Query) (*Response, error) {
 // If an error occurs, Response may contain additional information if populated.
 func (c *Client) Write(bp BatchPoints) (*Response, error) {
 	u := c.url
-	u.Path = "write"
+	u.Path = c.writePath
 
 	var b bytes.Buffer
 	for _, p := range bp.Points {
@@ -330,28 +360,59 @@ func (c *Client) Write(bp BatchPoints) (*Response, error) {
 }
 
 // WriteLineProtocol takes a string with line returns to delimit each write
-// If successful, error is nil and Response is nil
+// If successful, error is nil and Response is nil unless the server returned a
+// JSON body, in which case it is decoded into Response.
 // If an error occurs, Response may contain additional information if populated.
 func (c *Client) WriteLineProtocol(data, database, retentionPolicy, precision, writeConsistency string) (*Response, error) {
-	u := c.url
-	u.Path = "write"
+	return c.WriteLineProtocolReader(strings.NewReader(data), database, retentionPolicy, precision, writeConsistency)
+}
 
-	r := strings.NewReader(data)
+// WriteLineProtocolReader is like WriteLineProtocol but streams the line
+// protocol from r instead of requiring it to be held in a single string.
+// If r has a Len method, it is used as the content length of the request.
+func (c *Client) WriteLineProtocolReader(r io.Reader, database, retentionPolicy, precision, writeConsistency string) (*Response, error) {
+	params := url.Values{}
+	params.Set("db", database)
+	params.Set("rp", retentionPolicy)
+	params.Set("precision", precision)
+	params.Set("consistency", writeConsistency)
+	return c.writeLineProtocol(c.writePath, r, params)
+}
+
+// WriteBucketLineProtocolReader is like WriteLineProtocolReader but writes to
+// a bucket of an organization through the /api/v2/write endpoint of
+// InfluxDB 2.x, or the compatible endpoint of InfluxDB 1.8 and later.
+// Precision is one of ns, us, ms or s.
+func (c *Client) WriteBucketLineProtocolReader(r io.Reader, org, bucket, precision string) (*Response, error) {
+	params := url.Values{}
+	params.Set("org", org)
+	params.Set("bucket", bucket)
+	params.Set("precision", precision)
+	return c.writeLineProtocol(path.Join(path.Dir(c.writePath), "api/v2/write"), r, params)
+}
+
+// writeLineProtocol posts the line protocol read from r to endpoint.
+func (c *Client) writeLineProtocol(endpoint string, r io.Reader, params url.Values) (*Response, error) {
+	u := c.url
+	u.Path = endpoint
 
 	req, err := http.NewRequest("POST", u.String(), r)
 	if err != nil {
 		return nil, err
 	}
+	if c.chunked {
+		// An unknown length makes the transport use chunked encoding
+		req.ContentLength = -1
+	} else if l, ok := r.(interface {
+		Len() int
+	}); ok && req.ContentLength == 0 {
+		req.ContentLength = int64(l.Len())
+	}
 	req.Header.Set("Content-Type", "")
 	req.Header.Set("User-Agent", c.userAgent)
 	if c.username != "" {
 		req.SetBasicAuth(c.username, c.password)
 	}
-	params := req.URL.Query()
-	params.Set("db", database)
-	params.Set("rp", retentionPolicy)
-	params.Set("precision", precision)
-	params.Set("consistency", writeConsistency)
 	req.URL.RawQuery = params.Encode()
 
 	resp, err := c.httpClient.Do(req)
@@ -367,14 +428,35 @@ func (c *Client) WriteLineProtocol(data, database, retentionPolicy, precision, w
 	}
 
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf(string(body))
+		err := &WriteError{StatusCode: resp.StatusCode, Header: resp.Header, Body: string(body)}
 		response.Err = err
 		return &response, err
 	}
 
+	// Some proxies report failures in the body of a successful response, so
+	// pass along any JSON body that was returned.
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &response); err == nil {
+			return &response, nil
+		}
+	}
+
 	return nil, nil
 }
 
+// WriteError is returned by WriteLineProtocol when the server responds with
+// an unsuccessful status code.
+type WriteError struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+// Error returns the body of the response.
+func (e *WriteError) Error() string {
+	return e.Body
+}
+
 // Ping will check to see if the server is up
 // Ping returns how long the request took, the version of the server it connected to, and an error if one occurred.
 func (c *Client) Ping() (time.Duration, string, error) {
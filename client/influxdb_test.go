@@ -2,6 +2,7 @@ package client_test
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -249,6 +251,139 @@ func TestClient_Write(t *testing.T) {
 	}
 }
 
+func TestClient_WriteLineProtocol_Body(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"error":"partial write"}`))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	config := client.Config{URL: *u}
+	c, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	r, err := c.WriteLineProtocol("cpu value=1", "db0", "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if r == nil || r.Error() == nil || r.Error().Error() != "partial write" {
+		t.Fatalf("unexpected response. expected %v, actual %v", "partial write", r)
+	}
+}
+
+func TestClient_WriteBucketLineProtocolReader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		params := r.URL.Query()
+		if params.Get("org") != "org0" || params.Get("bucket") != "db0/rp0" || params.Get("precision") != "s" {
+			t.Errorf("unexpected params: %s", r.URL.RawQuery)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	config := client.Config{URL: *u}
+	c, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	if _, err := c.WriteBucketLineProtocolReader(strings.NewReader("cpu value=1 1464026335"), "org0", "db0/rp0", "s"); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+}
+
+func TestClient_Paths(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/query") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	config := client.Config{URL: *u, WritePath: "/influxdb/write", QueryPath: "/influxdb/query"}
+	c, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	if _, err := c.Query(client.Query{Command: "SHOW DATABASES"}); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if _, err := c.WriteLineProtocol("cpu value=1", "db0", "", "", ""); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if _, err := c.WriteBucketLineProtocolReader(strings.NewReader("cpu value=1"), "org0", "db0", "s"); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if exp := []string{"/influxdb/query", "/influxdb/write", "/influxdb/api/v2/write"}; !reflect.DeepEqual(paths, exp) {
+		t.Fatalf("unexpected paths.  expected %v, actual %v", exp, paths)
+	}
+}
+
+func TestClient_ChunkedWrites(t *testing.T) {
+	var encodings [][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.TransferEncoding)
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != "cpu value=1" {
+			t.Errorf("unexpected body: %q", body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	for _, chunked := range []bool{false, true} {
+		c, err := client.NewClient(client.Config{URL: *u, ChunkedWrites: chunked})
+		if err != nil {
+			t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+		}
+		if _, err := c.WriteLineProtocol("cpu value=1", "db0", "", "", ""); err != nil {
+			t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+		}
+	}
+	if exp := [][]string{nil, {"chunked"}}; !reflect.DeepEqual(encodings, exp) {
+		t.Fatalf("unexpected transfer encodings.  expected %v, actual %v", exp, encodings)
+	}
+}
+
+func TestClient_WriteLineProtocol_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`too many requests`))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	config := client.Config{URL: *u}
+	c, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	_, err = c.WriteLineProtocol("cpu value=1", "db0", "", "", "")
+	e, ok := err.(*client.WriteError)
+	if !ok {
+		t.Fatalf("unexpected error type. expected %T, actual %T", e, err)
+	}
+	if e.StatusCode != http.StatusTooManyRequests || e.Header.Get("Retry-After") != "2" || e.Error() != "too many requests" {
+		t.Fatalf("unexpected error: %#v", e)
+	}
+}
+
 func TestClient_UserAgent(t *testing.T) {
 	receivedUserAgent := ""
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -805,6 +940,41 @@ war3JNM1mGB3o2iAtuOJlFIKLpI1x+1e8pI=
 	}
 }
 
+func TestClient_TLSConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	cert, err := x509.ParseCertificate(ts.TLS.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	u, _ := url.Parse(ts.URL)
+	tests := []struct {
+		name      string
+		unsafeSsl bool
+		tlsConfig *tls.Config
+		ok        bool
+	}{
+		{name: "untrusted certificate"},
+		{name: "trusted certificate", tlsConfig: &tls.Config{RootCAs: pool}, ok: true},
+		{name: "tls config overrides unsafe ssl", unsafeSsl: true, tlsConfig: &tls.Config{}},
+	}
+	for _, test := range tests {
+		c, err := client.NewClient(client.Config{URL: *u, UnsafeSsl: test.unsafeSsl, TLSConfig: test.tlsConfig})
+		if err != nil {
+			t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+		}
+		if _, _, err := c.Ping(); (err == nil) != test.ok {
+			t.Errorf("%s: unexpected ping error: %v", test.name, err)
+		}
+	}
+}
+
 func TestChunkedResponse(t *testing.T) {
 	s := `{"results":[{},{}]}{"results":[{}]}`
 	r := client.NewChunkedResponse(strings.NewReader(s))
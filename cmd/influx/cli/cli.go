@@ -161,7 +161,8 @@ func (c *CommandLine) Run() error {
 		config.URL = u
 
 		i := v8.NewImporter(config)
-		if err := i.Import(); err != nil {
+		defer i.Close()
+		if _, err := i.Import(); err != nil {
 			err = fmt.Errorf("ERROR: %s\n", err)
 			return err
 		}
@@ -0,0 +1,84 @@
+package v8
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+)
+
+// checkpoint records the hashes of batches that have been successfully
+// written so that a re-run of the same import can skip them. Batches are
+// looked up by the importer while its workers record the ones written.
+type checkpoint struct {
+	mu     sync.Mutex // guards hashes and writes to f
+	f      *os.File
+	hashes map[string]struct{}
+}
+
+// openCheckpoint opens the file at path for appending new hashes, creating
+// it if it does not exist. With resume, the hashes already recorded in it
+// are loaded, otherwise they are discarded.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	flag := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checkpoint{f: f, hashes: make(map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if h := strings.TrimSpace(scanner.Text()); h != "" {
+			c.hashes[h] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// has returns true if the batch hash h has already been recorded.
+func (c *checkpoint) has(h string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.hashes[h]
+	return ok
+}
+
+// add records the batch hash h.
+func (c *checkpoint) add(h string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.f.WriteString(h + "\n"); err != nil {
+		return err
+	}
+	c.hashes[h] = struct{}{}
+	return nil
+}
+
+// Close closes the underlying checkpoint file.
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// batchHash returns a hash identifying a batch of lines written to a database
+// and retention policy.
+func batchHash(database, retentionPolicy string, lines []string) string {
+	h := sha1.New()
+	h.Write([]byte(database))
+	h.Write([]byte{0})
+	h.Write([]byte(retentionPolicy))
+	for _, line := range lines {
+		h.Write([]byte{0})
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,131 @@
+package v8
+
+import (
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/influxql"
+)
+
+// runDeferredDDL executes the DDL statements queued by queryExecutor, up to
+// Config.DDLConcurrency at a time. Statements on the same database run in
+// the order of the dump, so a database is created before its retention
+// policies. Any other statement, such as CREATE USER or GRANT, waits for
+// the statements before it and runs on its own. Once a statement fails with
+// Config.StopOnDDLError set, no further statements are started, but those
+// already running on other databases still complete.
+func (i *Importer) runDeferredDDL() {
+	commands := i.deferredDDL
+	i.deferDDL = false
+	i.deferredDDL = nil
+	if len(commands) == 0 {
+		return
+	}
+
+	start := time.Now()
+	var serial time.Duration
+	var executed int
+	var group []string
+	execute := func(commands []string) {
+		if i.err != nil {
+			return
+		}
+		d, n := i.executeConcurrently(commands)
+		serial += d
+		executed += n
+	}
+	for _, command := range commands {
+		if _, ok := ddlDatabase(command); ok {
+			group = append(group, command)
+			continue
+		}
+		execute(group)
+		execute([]string{command})
+		group = nil
+	}
+	execute(group)
+
+	elapsed := time.Since(start)
+	i.logf("Executed %d DDL statements in %s, %.1fx as fast as one at a time\n", executed, elapsed, float64(serial)/float64(elapsed))
+}
+
+// executeConcurrently executes commands with one goroutine per database and
+// up to Config.DDLConcurrency statements at a time. It returns the time the
+// statements took in total and how many were executed, which is fewer than
+// given if one failed and stopped the import.
+func (i *Importer) executeConcurrently(commands []string) (time.Duration, int) {
+	var order []string
+	queues := make(map[string][]string)
+	for _, command := range commands {
+		db, _ := ddlDatabase(command)
+		if _, ok := queues[db]; !ok {
+			order = append(order, db)
+		}
+		queues[db] = append(queues[db], command)
+	}
+
+	var (
+		mu       sync.Mutex // guards total, executed and i.err
+		total    time.Duration
+		executed int
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, i.config.DDLConcurrency)
+	for _, db := range order {
+		wg.Add(1)
+		go func(commands []string) {
+			defer wg.Done()
+			for _, command := range commands {
+				sem <- struct{}{}
+				mu.Lock()
+				stopped := i.err != nil
+				mu.Unlock()
+				if stopped {
+					<-sem
+					return
+				}
+				start := time.Now()
+				err := i.query(command)
+				d := time.Since(start)
+				<-sem
+
+				mu.Lock()
+				total += d
+				executed++
+				if err != nil {
+					i.ddlError(command, err)
+				}
+				mu.Unlock()
+			}
+		}(queues[db])
+	}
+	wg.Wait()
+	return total, executed
+}
+
+// ddlDatabase returns the database a statement creates, drops or changes the
+// retention policies or continuous queries of. ok is false for any other
+// statement.
+func ddlDatabase(command string) (db string, ok bool) {
+	stmt, err := influxql.ParseStatement(command)
+	if err != nil {
+		return "", false
+	}
+	switch stmt := stmt.(type) {
+	case *influxql.CreateDatabaseStatement:
+		return stmt.Name, true
+	case *influxql.DropDatabaseStatement:
+		return stmt.Name, true
+	case *influxql.CreateRetentionPolicyStatement:
+		return stmt.Database, true
+	case *influxql.AlterRetentionPolicyStatement:
+		return stmt.Database, true
+	case *influxql.DropRetentionPolicyStatement:
+		return stmt.Database, true
+	case *influxql.CreateContinuousQueryStatement:
+		return stmt.Database, true
+	case *influxql.DropContinuousQueryStatement:
+		return stmt.Database, true
+	}
+	return "", false
+}
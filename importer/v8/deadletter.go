@@ -0,0 +1,189 @@
+package v8
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DeadLetter is a single failed line as recorded in Config.DeadLetterPath.
+// Lines skipped by Config.ValidateLines are recorded with a Batch of 0.
+type DeadLetter struct {
+	Line            int    `json:"line"`
+	Batch           int    `json:"batch"`
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+	Text            string `json:"text"`
+	Error           string `json:"error"`
+}
+
+// deadLetterWriter writes failed lines to a dead-letter file as JSON lines.
+type deadLetterWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// createDeadLetterWriter creates or truncates the dead-letter file at path.
+func createDeadLetterWriter(path string) (*deadLetterWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &deadLetterWriter{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// write records every line of a failed batch and flushes them to the file.
+func (d *deadLetterWriter) write(batch int, database, retentionPolicy string, lines []string, lineNums []int, err error) error {
+	for n, line := range lines {
+		if e := d.enc.Encode(DeadLetter{
+			Line:            lineNums[n],
+			Batch:           batch,
+			Database:        database,
+			RetentionPolicy: retentionPolicy,
+			Text:            line,
+			Error:           err.Error(),
+		}); e != nil {
+			return e
+		}
+	}
+	return d.w.Flush()
+}
+
+// Close flushes and closes the dead-letter file.
+func (d *deadLetterWriter) Close() error {
+	if err := d.w.Flush(); err != nil {
+		d.f.Close()
+		return err
+	}
+	return d.f.Close()
+}
+
+// failedLinesWriter appends failed lines to a file as plain line protocol,
+// gzipped if the name of the file ends in .gz.
+type failedLinesWriter struct {
+	f  *os.File
+	gz *gzip.Writer
+	w  *bufio.Writer
+}
+
+// openFailedLinesWriter opens the file at path for appending, creating it if
+// necessary. Appending to a gzipped file adds another gzip member to it,
+// which readers of gzip decompress as if it were one.
+func openFailedLinesWriter(path string) (*failedLinesWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(f)
+		return &failedLinesWriter{f: f, gz: gz, w: bufio.NewWriter(gz)}, nil
+	}
+	return &failedLinesWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write appends the lines of a failed batch and flushes them to the file.
+func (w *failedLinesWriter) write(lines []string) error {
+	for _, line := range lines {
+		if _, err := w.w.WriteString(line); err != nil {
+			return err
+		}
+		if err := w.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Flush()
+	}
+	return nil
+}
+
+// Close flushes and closes the file, completing the gzip stream if any.
+func (w *failedLinesWriter) Close() error {
+	err := w.w.Flush()
+	if w.gz != nil {
+		if e := w.gz.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := w.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// ImportDeadLetters re-attempts the lines of a dead-letter file written by a
+// previous run, each in its original database and retention policy. The lines
+// are written as they were recorded, without applying the repairs and
+// rewrites again. Lines that fail again are recorded in Config.DeadLetterPath,
+// which therefore must not be path.
+func (i *Importer) ImportDeadLetters(ctx context.Context, path string) (Stats, error) {
+	i.running.Lock()
+	defer i.running.Unlock()
+	i.reset()
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+	if i.config.DeadLetterPath != "" && filepath.Clean(i.config.DeadLetterPath) == filepath.Clean(path) {
+		return Stats{}, fmt.Errorf("cannot re-import the dead-letter file %s into itself", path)
+	}
+	if err := i.validate(); err != nil {
+		return Stats{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer f.Close()
+
+	i.done = ctx.Done()
+	if err := i.connect(); err != nil {
+		return Stats{}, err
+	}
+	defer i.close()
+
+	defer i.logSummary()
+	if err := i.setup(); err != nil {
+		return Stats{}, err
+	}
+
+	i.startLimiter()
+
+	start := time.Now()
+	var total int
+	dec := json.NewDecoder(f)
+	for {
+		if err := ctx.Err(); err != nil {
+			if i.config.FlushOnCancel {
+				i.flush()
+			}
+			return i.Stats(), err
+		}
+		var d DeadLetter
+		if err := dec.Decode(&d); err == io.EOF {
+			break
+		} else if err != nil {
+			return i.Stats(), fmt.Errorf("reading %s: %s", path, err)
+		}
+		total++
+
+		i.setContext(d.Database, d.RetentionPolicy)
+		i.lineNum = d.Line
+		i.accumulate(d.Text, d.Database, d.RetentionPolicy, i.precisionOf(d.Text), start)
+	}
+	i.flush()
+
+	i.logf("Recovered %d of %d dead letters\n", i.totalInserts, total)
+	return i.Stats(), insertError(i.failedInserts)
+}
@@ -0,0 +1,95 @@
+package v8
+
+import (
+	"strconv"
+	"strings"
+)
+
+// resolveDuplicates resolves the lines of a batch that share a series key and
+// timestamp according to strategy, as described by Config.DuplicateStrategy.
+// It returns the remaining lines and their line numbers.
+func resolveDuplicates(strategy string, lines []string, lineNums []int) ([]string, []int) {
+	index := make(map[string]int, len(lines)) // series key and timestamp to position in out
+	var skip map[string]bool
+	out := make([]string, 0, len(lines))
+	outNums := make([]int, 0, len(lineNums))
+	for n, line := range lines {
+		key, fields, ts := splitLine(line)
+		k := key + " " + ts
+		j, ok := index[k]
+		if !ok || ts == "" {
+			index[k] = len(out)
+			out = append(out, line)
+			outNums = append(outNums, lineNums[n])
+			continue
+		}
+
+		switch strategy {
+		case "last":
+			out[j], outNums[j] = line, lineNums[n]
+		case "sum":
+			_, prev, _ := splitLine(out[j])
+			out[j] = joinLine(key, sumFields(prev, fields), ts)
+		case "skip":
+			if skip == nil {
+				skip = make(map[string]bool)
+			}
+			skip[k] = true
+		}
+	}
+
+	if skip == nil {
+		return out, outNums
+	}
+	lines, lineNums = out[:0], outNums[:0]
+	for n, line := range out {
+		if key, _, ts := splitLine(line); !skip[key+" "+ts] {
+			lines = append(lines, line)
+			lineNums = append(lineNums, outNums[n])
+		}
+	}
+	return lines, lineNums
+}
+
+// sumFields merges two field sets, adding up the values of fields that are
+// numeric in both. Other fields in both take the value in b.
+func sumFields(a, b string) string {
+	pairs := splitFields(a)
+	index := make(map[string]int, len(pairs))
+	for n, pair := range pairs {
+		index[fieldKey(pair)] = n
+	}
+	for _, pair := range splitFields(b) {
+		n, ok := index[fieldKey(pair)]
+		if !ok {
+			index[fieldKey(pair)] = len(pairs)
+			pairs = append(pairs, pair)
+			continue
+		}
+		eq := scanTo(pair, 0, '=', false)
+		if sum, ok := sumValues(pairs[n][eq+1:], pair[eq+1:]); ok {
+			pairs[n] = pair[:eq+1] + sum
+		} else {
+			pairs[n] = pair
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sumValues adds two integer or two float field values.
+func sumValues(a, b string) (string, bool) {
+	if strings.HasSuffix(a, "i") && strings.HasSuffix(b, "i") {
+		x, err1 := strconv.ParseInt(strings.TrimSuffix(a, "i"), 10, 64)
+		y, err2 := strconv.ParseInt(strings.TrimSuffix(b, "i"), 10, 64)
+		if err1 != nil || err2 != nil {
+			return "", false
+		}
+		return strconv.FormatInt(x+y, 10) + "i", true
+	}
+	x, err1 := strconv.ParseFloat(a, 64)
+	y, err2 := strconv.ParseFloat(b, 64)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+	return strconv.FormatFloat(x+y, 'f', -1, 64), true
+}
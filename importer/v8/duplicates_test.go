@@ -0,0 +1,53 @@
+package v8
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveDuplicates(t *testing.T) {
+	lines := []string{
+		"cpu,host=a value=1,n=1i,s=\"x\" 10",
+		"cpu,host=b value=5 10",
+		"cpu,host=a value=2.5,n=2i,s=\"y\",extra=t 10",
+		"cpu,host=a value=3 20",
+		"cpu value=1",
+		"cpu value=1",
+	}
+	lineNums := []int{1, 2, 3, 4, 5, 6}
+
+	tests := []struct {
+		strategy string
+		lines    []string
+		lineNums []int
+	}{
+		{
+			strategy: "first",
+			lines:    []string{lines[0], lines[1], lines[3], lines[4], lines[5]},
+			lineNums: []int{1, 2, 4, 5, 6},
+		},
+		{
+			strategy: "last",
+			lines:    []string{lines[2], lines[1], lines[3], lines[4], lines[5]},
+			lineNums: []int{3, 2, 4, 5, 6},
+		},
+		{
+			strategy: "sum",
+			lines:    []string{`cpu,host=a value=3.5,n=3i,s="y",extra=t 10`, lines[1], lines[3], lines[4], lines[5]},
+			lineNums: []int{1, 2, 4, 5, 6},
+		},
+		{
+			strategy: "skip",
+			lines:    []string{lines[1], lines[3], lines[4], lines[5]},
+			lineNums: []int{2, 4, 5, 6},
+		},
+	}
+
+	for _, tt := range tests {
+		in := append([]string(nil), lines...)
+		got, gotNums := resolveDuplicates(tt.strategy, in, lineNums)
+		if !reflect.DeepEqual(got, tt.lines) || !reflect.DeepEqual(gotNums, tt.lineNums) {
+			t.Errorf("%s: unexpected lines: %q %v", tt.strategy, got, gotNums)
+		}
+	}
+}
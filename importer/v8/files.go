@@ -0,0 +1,217 @@
+package v8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileStats is the result of importing one of the files of ImportFiles.
+type FileStats struct {
+	Path string
+	Stats
+	Elapsed time.Duration
+	Err     error
+}
+
+// ImportFiles imports the dumps named by Config.Paths one after another and
+// stops at the first file that fails, unless Config.ContinueOnFileError is
+// set. A path may name a dump, a directory whose dumps are imported in name
+// order, or a glob pattern. The results of the files imported so far are
+// returned along with the totals.
+func (i *Importer) ImportFiles(ctx context.Context) ([]FileStats, Stats, error) {
+	i.running.Lock()
+	defer i.running.Unlock()
+	i.reset()
+	if err := ctx.Err(); err != nil {
+		return nil, Stats{}, err
+	}
+	paths, err := expandPaths(i.config.Paths)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	if len(paths) == 0 {
+		return nil, Stats{}, errors.New("no files to import")
+	}
+
+	if err := i.validate(); err != nil {
+		return nil, Stats{}, err
+	}
+
+	i.done = ctx.Done()
+	if err := i.connect(); err != nil {
+		return nil, Stats{}, err
+	}
+	defer i.close()
+
+	defer i.logSummary()
+	if err := i.setup(); err != nil {
+		return nil, Stats{}, err
+	}
+
+	var files []FileStats
+	var failed []string
+	for _, path := range paths {
+		before, start := i.Stats(), time.Now()
+		i.resetFile()
+		err := i.importFile(ctx, path)
+		fs := FileStats{
+			Path:    path,
+			Stats:   i.Stats().sub(before),
+			Elapsed: time.Since(start),
+			Err:     err,
+		}
+		if fs.Err == nil {
+			fs.Err = insertError(fs.Failed)
+		}
+		files = append(files, fs)
+
+		if fs.Err == nil {
+			continue
+		}
+		if !i.config.ContinueOnFileError || ctx.Err() != nil {
+			return files, i.Stats(), fmt.Errorf("%s: %s", path, fs.Err)
+		}
+		i.logf("error importing %s, continuing with the next file: %s\n", path, fs.Err)
+		failed = append(failed, path)
+	}
+
+	if len(failed) > 0 {
+		i.logf("Failed to import %d of %d files: %s\n", len(failed), len(paths), strings.Join(failed, ", "))
+		return files, i.Stats(), fmt.Errorf("%d of %d files failed to import", len(failed), len(paths))
+	}
+	return files, i.Stats(), i.postImport()
+}
+
+// resetFile clears what a previous file may have left behind, so that the
+// next file starts without a context and is not failed by the error that
+// stopped the previous one.
+func (i *Importer) resetFile() {
+	i.err = nil
+	i.database, i.retentionPolicy, i.org, i.bucket = "", "", "", ""
+	i.contextChanged = false
+	i.batches = i.batches[:0]
+	i.reversed = i.reversed[:0]
+}
+
+// sub returns the work done since o.
+func (s Stats) sub(o Stats) Stats {
+	return Stats{
+		Commands:        s.Commands - o.Commands,
+		Inserts:         s.Inserts - o.Inserts,
+		Failed:          s.Failed - o.Failed,
+		FailedTransient: s.FailedTransient - o.FailedTransient,
+		DedupedDDL:      s.DedupedDDL - o.DedupedDDL,
+		WriteRequests:   s.WriteRequests - o.WriteRequests,
+		FullBatches:     s.FullBatches - o.FullBatches,
+		PartialBatches:  s.PartialBatches - o.PartialBatches,
+		Series:          s.Series - o.Series,
+		Filtered:        s.Filtered - o.Filtered,
+	}
+}
+
+// expandPaths expands the directories and glob patterns in paths into the
+// files they contain. URLs are left alone.
+func expandPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if isURL(path) {
+			files = append(files, path)
+			continue
+		}
+		if strings.ContainsAny(path, "*?[") {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+			continue
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil || !fi.IsDir() {
+			// Let the import report files that cannot be read.
+			files = append(files, path)
+			continue
+		}
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			if fi.Mode().IsRegular() {
+				files = append(files, filepath.Join(path, fi.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// multiFileReader reads the files at paths one after another, as if they
+// were one file. Each file is opened when it is reached and decompressed on
+// its own, and a file missing its final newline is given one so that its
+// last line is not joined to the first line of the next.
+type multiFileReader struct {
+	i     *Importer
+	paths []string
+
+	path      string    // of the open file
+	r         io.Reader // of the open file
+	closeFile func()
+	last      byte // last byte read
+}
+
+func (m *multiFileReader) Read(p []byte) (int, error) {
+	for {
+		if m.r == nil {
+			if len(m.paths) == 0 {
+				return 0, io.EOF
+			}
+			path := m.paths[0]
+			m.paths = m.paths[1:]
+			f, err := m.i.openPath(path)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %s", path, err)
+			}
+			r, closeFile, err := m.i.decompress(f, func() { f.Close() })
+			if err != nil {
+				return 0, fmt.Errorf("%s: %s", path, err)
+			}
+			m.path, m.r, m.closeFile = path, r, closeFile
+		}
+
+		n, err := m.r.Read(p)
+		if n > 0 {
+			m.last = p[n-1]
+		}
+		if err == io.EOF {
+			m.close()
+			err = nil
+			if n == 0 {
+				if m.last != 0 && m.last != '\n' && len(p) > 0 {
+					p[0], m.last = '\n', '\n'
+					return 1, nil
+				}
+				continue
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %s", m.path, err)
+		}
+		return n, err
+	}
+}
+
+// close closes the open file, if any.
+func (m *multiFileReader) close() {
+	if m.closeFile != nil {
+		m.closeFile()
+	}
+	m.r, m.closeFile = nil, nil
+}
@@ -0,0 +1,155 @@
+package v8
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/models"
+)
+
+// GenSpec describes the synthetic data written by GenerateAndImport.
+type GenSpec struct {
+	Database        string
+	RetentionPolicy string
+
+	// Measurements is the number of measurements, named m0, m1, ...
+	Measurements int
+
+	// Series is the number of series per measurement. Series are told apart
+	// by the values of their tags, named tag0, tag1, ...
+	Series int
+	Tags   int
+
+	// Fields is the number of float fields per point, named field0, field1, ...
+	Fields int
+
+	// Every series gets a point at Start and every Interval after it, up to
+	// but excluding End.
+	Start    time.Time
+	End      time.Time
+	Interval time.Duration
+
+	// Seed seeds the field values, so the same spec always generates the
+	// same points.
+	Seed int64
+}
+
+// GenerateAndImport writes the points described by spec through the same
+// batching, throttling and write path as Import, without a dump file. The
+// database is created first.
+func (i *Importer) GenerateAndImport(spec GenSpec) (Stats, error) {
+	i.running.Lock()
+	defer i.running.Unlock()
+	i.reset()
+	if err := i.validate(); err != nil {
+		return Stats{}, err
+	}
+	if err := i.connect(); err != nil {
+		return Stats{}, err
+	}
+	defer i.close()
+
+	defer i.logSummary()
+	if err := i.setup(); err != nil {
+		return Stats{}, err
+	}
+
+	i.startLimiter()
+
+	i.queryExecutor("CREATE DATABASE " + influxql.QuoteIdent(spec.Database))
+	i.setContext(spec.Database, spec.RetentionPolicy)
+
+	start := time.Now()
+	g := newGenerator(spec, i.config.Precision)
+	for line, ok := g.next(); ok; line, ok = g.next() {
+		i.lineNum++
+		i.batchAccumulator(line, start)
+		if i.err != nil {
+			break
+		}
+	}
+	i.flush()
+
+	stats := i.Stats()
+	if i.err != nil {
+		return stats, i.err
+	}
+	return stats, insertError(i.failedInserts)
+}
+
+// generator generates the lines of a GenSpec one at a time, ordered by time.
+type generator struct {
+	spec GenSpec
+	rand *rand.Rand
+	keys []string // series keys of every measurement
+	mult int64    // nanoseconds per unit of the timestamp precision
+
+	t time.Time
+	n int // index into keys of the next line
+}
+
+func newGenerator(spec GenSpec, precision string) *generator {
+	if spec.Measurements <= 0 {
+		spec.Measurements = 1
+	}
+	if spec.Series <= 0 {
+		spec.Series = 1
+	}
+	if spec.Tags <= 0 && spec.Series > 1 {
+		spec.Tags = 1
+	}
+	if spec.Fields <= 0 {
+		spec.Fields = 1
+	}
+	if spec.Interval <= 0 {
+		spec.Interval = time.Second
+	}
+
+	g := &generator{
+		spec: spec,
+		rand: rand.New(rand.NewSource(spec.Seed)),
+		mult: models.GetPrecisionMultiplier(precision),
+		t:    spec.Start,
+	}
+	for m := 0; m < spec.Measurements; m++ {
+		for s := 0; s < spec.Series; s++ {
+			key := "m" + strconv.Itoa(m)
+			for t := 0; t < spec.Tags; t++ {
+				key += ",tag" + strconv.Itoa(t) + "=v" + strconv.Itoa(s)
+			}
+			g.keys = append(g.keys, key)
+		}
+	}
+	return g
+}
+
+// next returns the next line, or false once End is reached.
+func (g *generator) next() (string, bool) {
+	if g.n == len(g.keys) {
+		g.t = g.t.Add(g.spec.Interval)
+		g.n = 0
+	}
+	if !g.t.Before(g.spec.End) {
+		return "", false
+	}
+
+	buf := []byte(g.keys[g.n])
+	for f := 0; f < g.spec.Fields; f++ {
+		if f == 0 {
+			buf = append(buf, ' ')
+		} else {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, "field"...)
+		buf = strconv.AppendInt(buf, int64(f), 10)
+		buf = append(buf, '=')
+		buf = strconv.AppendFloat(buf, g.rand.Float64(), 'f', -1, 64)
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, g.t.UnixNano()/g.mult, 10)
+
+	g.n++
+	return string(buf), true
+}
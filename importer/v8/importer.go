@@ -3,250 +3,2618 @@ package v8 // import "github.com/influxdata/influxdb/importer/v8"
 
 import (
 	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/zstd"
 )
 
-const batchSize = 5000
+// versionRegexp matches a comment naming the version of InfluxDB a dump was exported from.
+var versionRegexp = regexp.MustCompile(`(?i)\bversion\b\s*:?\s*v?(\d+\.\d+(?:\.\d+)*)`)
+
+// createDatabaseRegexp matches a CREATE DATABASE statement.
+var createDatabaseRegexp = regexp.MustCompile(`(?i)^\s*CREATE\s+DATABASE\s`)
+
+// Magic numbers starting compressed files.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressionFormat is the compression of a dump.
+type CompressionFormat int
+
+const (
+	// CompressionAuto detects gzip, bzip2 and zstd dumps by their magic
+	// numbers and reads anything else as uncompressed.
+	CompressionAuto CompressionFormat = iota
+	CompressionNone
+	CompressionGzip
+	CompressionBzip2
+	CompressionZstd
+)
+
+// NoProgress, given as Config.ProgressEveryLines, disables periodic
+// progress reporting. Zero cannot be used for this as it selects the
+// default interval.
+const NoProgress = -1
+
+// errWriteRejected is returned when Config.SuccessFunc rejects a write the client reported as successful.
+var errWriteRejected = errors.New("write rejected by success function")
+
+// ErrFileRequired is returned by Import when Config names no dump to import.
+var ErrFileRequired = errors.New("file argument required")
+
+// ErrNoDML is returned by Import when Config.RequireDML is set and the dump
+// ends without a # DML section.
+var ErrNoDML = errors.New("dump has no # DML section")
+
+// ConnectionError is returned when the server does not answer the ping made
+// before an import.
+type ConnectionError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("failed to connect to %s: %s", e.Addr, e.Err)
+}
+
+// Unwrap returns the error of the ping.
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+const (
+	// defaultBatchSize is the number of points per write request when
+	// Config.BatchSize is unset.
+	defaultBatchSize = 5000
+
+	// defaultRetryAfter is how long to wait before retrying a rate limited
+	// write when the server does not say.
+	defaultRetryAfter = time.Second
+
+	// defaultMaxRateLimitRetries is the number of times a rate limited
+	// write is retried when Config.MaxRateLimitRetries is unset.
+	defaultMaxRateLimitRetries = 10
+
+	// defaultProgressEveryLines is the number of points batched between
+	// progress log messages when Config.ProgressEveryLines is unset.
+	defaultProgressEveryLines = 100000
+)
 
 // Config is the config used to initialize a Importer importer
 type Config struct {
-	Path       string // Path to import data.
-	Version    string
-	Compressed bool // Whether import data is gzipped.
-	PPS        int  // points per second importer imports with.
+	Path       string // Path or http(s) URL of import data, or "-" for standard input.
+	Version    string // Version of the importer, sent in the default User-Agent.
+	Compressed bool   // Whether import data is gzipped, the same as CompressionFormat Gzip.
+	PPS        int    // points per second importer imports with, see also SetPPS.
+
+	// CompressionFormat is the compression of the dump. The zero value
+	// detects it.
+	CompressionFormat CompressionFormat
+
+	// Concurrency is the number of batches written at once. With more than
+	// one, batches may be written out of order, and BeforeWrite,
+	// SuccessFunc and Sink may be called from several goroutines at once.
+	// The PPS limit applies to all writes together.
+	Concurrency int
+
+	// BatchSize is the number of points written per request. Zero or less
+	// uses the default of 5000.
+	BatchSize int
+
+	// MaxBatchBytes, if positive, also limits the size of the body of each
+	// write request. A batch is written early rather than grow past it, so
+	// only a single line longer than MaxBatchBytes is sent in a larger
+	// request.
+	MaxBatchBytes int
+
+	// Logger, if set, receives all log output in place of the standard
+	// logger.
+	Logger *log.Logger
+
+	// SummaryFormat is "text", the default, to only log a summary of the
+	// import, or "json" to also write the ImportResult of Import as a single
+	// JSON object to SummaryWriter, or standard error if it is nil.
+	SummaryFormat string
+	SummaryWriter io.Writer
+
+	// PathUsername and PathPassword, or PathBearerToken, authenticate the
+	// request for a dump whose path is an http or https URL.
+	PathUsername    string
+	PathPassword    string
+	PathBearerToken string
+
+	// Reader, if set, is read by Import in place of Path, which is then
+	// ignored. It is not closed. CompressionFormat applies to it as to a
+	// file.
+	Reader io.Reader
+
+	// ProgressEveryLines is the number of points batched for writing
+	// between progress log messages. Every DML line that is not skipped
+	// is one point, so skipped lines, comments and blank lines are not
+	// counted. Points are counted when batched rather than when written,
+	// so that a small import still reports progress before its only batch
+	// is full. Zero uses the default of 100000, and NoProgress or any
+	// other negative value disables periodic progress reporting.
+	ProgressEveryLines int
+
+	// ProgressFunc, if set, is called with the number of points processed
+	// and failed so far and the points per second whenever progress is
+	// logged, and once more when the end of the DML is reached. Points are
+	// processed once batched, as counted by ProgressEveryLines, including
+	// those later found to be duplicates or written by an earlier run, so
+	// processed never decreases. Failures are counted as their batches are
+	// written. It is never called from more than one goroutine at a time.
+	ProgressFunc func(processed, failed int, pps float64)
+
+	// CountLinesFirst reads the dump at Path once before importing it to
+	// count its DML lines, so that progress is also logged as a percentage
+	// and TotalLines returns the count. Dumps that can only be read once,
+	// from Reader, standard input or a URL, are not counted.
+	CountLinesFirst bool
+
+	// TimezoneOffset is added to the timestamp of every imported point to
+	// correct dumps that were exported in local time instead of UTC. A dump
+	// exported in UTC-5 needs an offset of 5h. The offset is truncated to the
+	// write precision and points without a timestamp are left alone. It is
+	// not a generic time shift for moving data to another period, which the
+	// importer does not offer: it only undoes the exporter's UTC offset, so
+	// the corrected timestamps are the true times of the points.
+	TimezoneOffset time.Duration
+
+	// StartTime and EndTime, if not zero, import only points with timestamps
+	// in [StartTime, EndTime), after TimezoneOffset is applied. Points
+	// without a timestamp are given the server's time on write and are
+	// skipped unless KeepUntimedPoints is set.
+	StartTime         time.Time
+	EndTime           time.Time
+	KeepUntimedPoints bool
+
+	// IncludeMeasurements, if set, imports only the measurements matching
+	// one of its glob patterns, such as "cpu*". ExcludeMeasurements skips
+	// the measurements matching one of its patterns. Skipped points are
+	// counted in Stats.Filtered.
+	IncludeMeasurements []string
+	ExcludeMeasurements []string
+
+	// BeforeWrite, if set, is called with each batch before it is written.
+	// Returning an error vetoes the batch, which is then counted as failed.
+	BeforeWrite func(database, retentionPolicy string, lines []string) error
+
+	// SuccessFunc, if set, decides whether a write succeeded based on the
+	// response and error returned by the client. It is needed for gateways
+	// that report failures in the body of a successful response. When nil, a
+	// write succeeds if no error was returned.
+	SuccessFunc func(resp *client.Response, err error) bool
+
+	// CheckpointPath, if set, is a file in which a hash of every successfully
+	// written batch is recorded. Re-running the same import with the same
+	// checkpoint and Resume skips batches whose hash is already recorded,
+	// making re-runs idempotent even when the input is compressed or
+	// reordered. This is how an interrupted import is resumed: the dump is
+	// read again from the start, its DDL is rerun, which is harmless for
+	// statements that already succeeded, and only the batches that were not
+	// written are sent. A batch is only recognized if it holds the same
+	// lines, so the resumed import must use the same dump and BatchSize.
+	CheckpointPath string
+
+	// Resume honors the batches recorded in an existing CheckpointPath.
+	// Without it the file is emptied and the import starts over.
+	Resume bool
+
+	// DeadLetterPath, if set, is a file to which every line of a failed batch
+	// is written as a JSON object holding the line, its original line number,
+	// the batch id, its database and retention policy and the error returned
+	// by the server. It replaces the plain dump of failed lines to stdout.
+	DeadLetterPath string
+
+	// FailedLinesPath, if set, is a file to which every line of a failed
+	// batch is appended as it was sent, so that the file can be imported
+	// again once the cause is fixed. It replaces the plain dump of failed
+	// lines to stdout. The file is gzipped if its name ends in .gz.
+	FailedLinesPath string
+
+	// Schema, if set, maps measurement names to the field keys they are
+	// expected to have. Lines of a listed measurement with a field key that
+	// is not expected are rejected, or only logged if SchemaWarnOnly is set.
+	// Only the presence of field keys is checked, not their types, and
+	// measurements that are not listed are not checked.
+	Schema         map[string][]string
+	SchemaWarnOnly bool
+
+	// StreamWrites streams each batch into the write request instead of
+	// joining it into a single string first, reducing peak memory use on
+	// large batches. Set ChunkedWrites as well for proxies that buffer
+	// requests with a Content-Length.
+	StreamWrites bool
+
+	// OnContextChange, if set, is called with the new database and retention
+	// policy whenever a "# CONTEXT-" header switches the destination of the
+	// lines that follow. Lines batched for the previous context are written
+	// before it is called.
+	OnContextChange func(database, retentionPolicy string)
+
+	// LineRanges, if set, limits the import to the points on the given lines
+	// of the input, such as the line numbers reported in a dead-letter file.
+	// DDL statements and context headers are always processed.
+	LineRanges []LineRange
+
+	// Repair enables every repair below in one switch, making most old dumps
+	// importable. Each repair can also be enabled on its own.
+	Repair bool
+
+	// TrimLines trims surrounding whitespace, such as the carriage return
+	// left behind by CRLF line endings, from every line.
+	TrimLines bool
+
+	// EscapeTagValues escapes unescaped equals signs in tag values.
+	EscapeTagValues bool
+
+	// SortTags sorts the tags of every line by key.
+	SortTags bool
+
+	// DropEmptyFieldLines drops lines that have no fields.
+	DropEmptyFieldLines bool
+
+	// FixPrecision scales timestamps written in seconds, milliseconds or
+	// microseconds to nanoseconds when importing with nanosecond precision.
+	FixPrecision bool
+
+	// Sink, if set, receives the imported points in place of the server and
+	// no connection to a server is made. DDL statements are passed on to
+	// sinks implementing DDLSink and skipped otherwise. The caller is
+	// responsible for closing the sink.
+	Sink Sink
+
+	// Client, if set, is used to talk to the server in place of a client
+	// created from the embedded client config, for instance to test an
+	// import without a server.
+	Client Client
+
+	// WriteTimeout, if positive, bounds every request made to the server,
+	// in place of the Timeout of the embedded client config. A write that
+	// times out fails its batch and counts as a transient failure. It does
+	// not apply to Client, and the import as a whole has no deadline: use
+	// ImportContext to bound it.
+	WriteTimeout time.Duration
+
+	// SampleRatio, if between 0 and 1, imports only that fraction of the
+	// series in the dump. Series are picked by a hash of their series key,
+	// so every series is either imported in full or dropped, and the same
+	// series are picked on every run.
+	SampleRatio float64
+
+	// ValidateLines parses every line before it is batched so that an
+	// invalid line is logged and skipped instead of failing its whole batch.
+	// Skipped lines are also written to DeadLetterPath, with batch 0.
+	// StopOnFirstInvalid instead aborts the import at the first invalid line.
+	ValidateLines      bool
+	StopOnFirstInvalid bool
+
+	// StopOnDDLError aborts the import at the first DDL statement that
+	// fails, such as a CREATE DATABASE denied for lack of permissions,
+	// instead of logging the error and going on to write points.
+	StopOnDDLError bool
+
+	// DowngradeConsistencyOnFailure retries a batch at consistency "one"
+	// when a write at consistency "quorum" or "all" fails as a partial
+	// write, as when a node of a cluster is down. The points written that
+	// way are logged, as they have weaker guarantees.
+	DowngradeConsistencyOnFailure bool
+
+	// RequireDML fails the import with ErrNoDML if the dump ends without a
+	// # DML section, as a truncated dump may. By default only a warning is
+	// logged.
+	RequireDML bool
+
+	// MeasurementPrecision maps measurement names to the precision of their
+	// timestamps, for dumps in which measurements were written with
+	// different precisions. Points of each precision are batched and written
+	// separately. Measurements that are not listed use Precision.
+	MeasurementPrecision map[string]string
+
+	// DedupeDDL skips DDL statements that were already executed earlier in
+	// the import, such as the CREATE DATABASE statements repeated by each of
+	// several concatenated dumps.
+	DedupeDDL bool
+
+	// KafkaBrokers, if set and Sink is not, makes the importer publish
+	// batches to KafkaTopic instead of writing them to the server. This
+	// requires building with the kafka build tag.
+	KafkaBrokers []string
+	KafkaTopic   string
+
+	// FlushOnCancel writes the lines batched so far when an import is
+	// canceled while processing DML, instead of discarding them. The
+	// lines are written without waiting for the PPS limit.
+	FlushOnCancel bool
+
+	// FlushInterval, if positive, writes the lines batched so far once that
+	// long has passed since the last write, so that the points of a slow
+	// stream are not held back until a batch fills up. The dump is then
+	// read in a goroutine of its own. Lines held back by ReverseTime are
+	// not written early, as that would break their order.
+	FlushInterval time.Duration
+
+	// ResultsWriter, if set, receives the outcome of every batch written as
+	// a BatchResult encoded as a line of JSON. Durations are in nanoseconds.
+	ResultsWriter io.Writer
+
+	// ReverseTime writes the points of each database and retention policy
+	// newest first. Because this requires sorting, all points of a
+	// database and retention policy are buffered in memory before any of
+	// them are written, so the dump should be split up if they do not fit,
+	// or SortWindow set.
+	ReverseTime bool
+
+	// SortWindow bounds the memory used by ReverseTime. Time is divided
+	// into windows of this length, and the buffered points are written
+	// whenever a point falls into a different window than the ones before
+	// it. Ordering is then only guaranteed within a window: windows are
+	// written in the order they appear in the dump.
+	SortWindow time.Duration
+
+	// MaxErrorsPerSecond limits how many errors are logged per second, so
+	// that widespread failures do not flood the log. The number of errors
+	// left out is logged once the next second starts. Zero means no limit.
+	MaxErrorsPerSecond int
+
+	// MaxFailures, if positive, aborts the import once more inserts than it
+	// have failed. No further batches are written, not even the points
+	// batched so far. Zero means never.
+	MaxFailures int
+
+	// MeasurementProgress adds the number of points written so far for the
+	// measurement being imported to the progress output. This costs parsing
+	// the measurement name of every line.
+	MeasurementProgress bool
+
+	// LoadQuery, if set, is run every LoadCheckInterval to measure the load
+	// of the server, read from the LoadColumn column of the first row it
+	// returns, e.g. the HeapInuse column of SHOW STATS FOR 'runtime'. While
+	// the load is above LoadThreshold, writes wait LoadBackoff before the
+	// load is measured again. Writes go ahead if the load cannot be measured.
+	LoadQuery         string
+	LoadColumn        string
+	LoadThreshold     float64
+	LoadCheckInterval time.Duration
+	LoadBackoff       time.Duration
+
+	// SkipDatabaseCreation skips every CREATE DATABASE statement, for users
+	// allowed to write to databases created beforehand but not to create
+	// them. Without it, every database a line is written to that the DDL
+	// of the dump did not create is created before the first line, so
+	// that dumps spanning several databases import as they are.
+	SkipDatabaseCreation bool
+
+	// RouteFunc, if set, picks the database and retention policy each line
+	// is written to in place of those of the dump's context, for instance to
+	// split a merged dump by measurement. An empty database or retention
+	// policy keeps that of the context. Lines are batched per destination,
+	// and each database is created the first time a line is routed to it.
+	RouteFunc func(line string) (database, retentionPolicy string)
+
+	// CreateRetentionPolicies creates every retention policy named by a
+	// CONTEXT-RETENTION-POLICY header that does not exist yet, with
+	// RetentionPolicyDuration (default INF) and RetentionPolicyReplication
+	// (default 1), before lines are written to it.
+	CreateRetentionPolicies    bool
+	RetentionPolicyDuration    string
+	RetentionPolicyReplication int
+
+	// PostImportQueries are run in order once every point was inserted,
+	// e.g. to create continuous queries or verify the imported data. Their
+	// errors are logged, and fail the import if PostImportFailOnError is set.
+	PostImportQueries     []string
+	PostImportFailOnError bool
+
+	// Paths names the dumps imported by ImportFiles: files, directories or
+	// glob patterns.
+	Paths []string
+
+	// SplitPaths names the files of a single dump split across them, read
+	// by Import in order when Path and Reader are not set: the DDL is in
+	// the first file and every file continues the DML of the one before.
+	// Directories and glob patterns are expanded as for Paths.
+	SplitPaths []string
+
+	// ContinueOnFileError makes ImportFiles go on to the next file when one
+	// fails, reporting all failed files at the end.
+	ContinueOnFileError bool
+
+	// BucketFunc, if set, maps the database and retention policy of every
+	// context to the organization and bucket of an InfluxDB 2.x server,
+	// which lines are then written to through the /api/v2/write endpoint.
+	BucketFunc func(database, retentionPolicy string) (org, bucket string)
+
+	// RenameMeasurements, RenameTags and RenameFields rename measurements,
+	// tag keys and field keys. They are applied in that order and every
+	// name is looked up once, so the result does not depend on the order
+	// of the rules: with {"a": "b", "b": "c"}, a becomes b and b becomes c.
+	// Names are unescaped and names without a rename are left unchanged.
+	// Other options naming measurements, such as Schema, use the names in
+	// the dump.
+	RenameMeasurements map[string]string
+	RenameTags         map[string]string
+	RenameFields       map[string]string
+
+	// NormalizeCase lowercases measurement names and tag keys, after any
+	// renames, so that names differing only in case are written as one.
+	// Tag values and fields are left as they are.
+	NormalizeCase bool
+
+	// DropFields are removed from every point and points left without a
+	// field are skipped. CoerceFloatFields and CoerceIntFields convert the
+	// numeric values of the named fields to floats or integers, truncating
+	// any fraction, to avoid type conflicts with fields that changed type.
+	// Fields are named as in the dump.
+	DropFields        []string
+	CoerceFloatFields []string
+	CoerceIntFields   []string
+
+	// AddTags are added to every point, for instance to tell apart points
+	// merged from several sources. A point that already has one of the tags
+	// keeps its value unless OverwriteTags is set.
+	AddTags       map[string]string
+	OverwriteTags bool
+
+	// RunIDTag, if set, is the key of a tag holding RunID that is added to
+	// every point, so that everything written by one import can later be
+	// found or deleted. Points that already have the tag keep their value.
+	// RunID is generated if empty.
+	RunIDTag string
+	RunID    string
+
+	// LineTransform, if set, is called with every line after the options
+	// above have been applied. The line it returns is written instead, or
+	// the line is skipped if it returns false.
+	LineTransform func(line string) (string, bool)
+
+	// DDLConcurrency is the maximum number of DDL statements executed at
+	// once. Statements on different databases run concurrently, which
+	// speeds up creating many databases on a cluster. By default, and with
+	// a Sink, statements are executed one at a time.
+	DDLConcurrency int
+
+	// OnThrottle, if set, is called with how long a batch waited whenever
+	// the PPS limit held it back.
+	OnThrottle func(waited time.Duration)
+
+	// MaxRateLimitRetries is the number of times a batch is retried while
+	// the server answers 429 Too Many Requests, after which it fails as a
+	// transient failure. Zero uses the default of 10 and a negative value
+	// disables the retries.
+	MaxRateLimitRetries int
+
+	// DuplicateStrategy decides what happens to points of a batch that
+	// share a series key and timestamp, which the server would otherwise
+	// merge with the last point written winning:
+	//
+	//   - "last" keeps only the last of the points.
+	//   - "first" keeps only the first of the points.
+	//   - "sum" merges the points, adding up fields that are integers or
+	//     floats in both. Other fields take the value of the later point.
+	//   - "skip" drops all of the points.
+	//
+	// Duplicates are only detected within a batch, between points with
+	// the tags in the same order. Points without a timestamp are never
+	// duplicates.
+	DuplicateStrategy string
+
+	// SeriesManifestPath, if set, is a file to which every distinct series
+	// key is written, one per line, as the series are first seen. Only a
+	// hash of each key is held in memory, so in rare cases of a collision
+	// a series may be missing.
+	SeriesManifestPath string
+
+	client.Config
+}
+
+// LineRange is an inclusive range of 1-based line numbers.
+type LineRange struct {
+	Start, End int
+}
+
+// NewConfig returns an initialized *Config
+func NewConfig() Config {
+	return Config{Config: client.NewConfig()}
+}
+
+// batch is a set of lines waiting to be written to the same database and
+// retention policy with the same precision.
+type batch struct {
+	database        string
+	retentionPolicy string
+	org             string // set with Config.BucketFunc
+	bucket          string
+	precision       string
+	lines           []string
+	lineNums        []int
+	size            int  // of the lines joined by newlines
+	full            bool // set when written for reaching a limit
+
+	id   int    // set by batchWrite
+	hash string // set by batchWrite when checkpointing
+}
+
+// reset empties b for reuse.
+func (b *batch) reset() {
+	b.lines = b.lines[:0]
+	b.lineNums = b.lineNums[:0]
+	b.size = 0
+	b.full = false
+}
+
+// add adds line to b, writing b first if the line would take it past
+// Config.MaxBatchBytes and after if it is then full. It returns true if a
+// batch was written.
+func (i *Importer) add(b *batch, line string, lineNum int) bool {
+	var written bool
+	if max := i.config.MaxBatchBytes; max > 0 && len(b.lines) > 0 && b.size+1+len(line) > max {
+		b.full = true
+		i.batchWrite(b)
+		b.reset()
+		written = true
+	}
+
+	if len(b.lines) > 0 {
+		b.size++
+	}
+	b.size += len(line)
+	b.lines = append(b.lines, line)
+	b.lineNums = append(b.lineNums, lineNum)
+
+	if len(b.lines) >= i.batchSize() || (i.config.MaxBatchBytes > 0 && b.size >= i.config.MaxBatchBytes) {
+		b.full = true
+		i.batchWrite(b)
+		b.reset()
+		written = true
+	}
+	return written
+}
+
+// Importer is the importer used for importing 0.8 data
+type Importer struct {
+	client  Client
+	mu      sync.Mutex // guards the outcome of batches sent by workers
+	logMu   sync.Mutex // guards the state of logErrorf
+	running sync.Mutex // held for the length of an import
+	config  Config
+
+	importState
+}
+
+// importState is the state of a single import, cleared by reset before the
+// next one.
+type importState struct {
+	jobs            chan *batch
+	pending         sync.WaitGroup // batches handed to the workers
+	database        string
+	retentionPolicy string
+	batches         []*batch
+	batchID         int
+	lineNum         int
+	contextChanged  bool
+	dumpVersion     string
+	repaired        []int
+	rateLimitWaits  int
+	downgraded      int // points written at consistency one, guarded by mu
+	sampledSeries   map[uint64]bool
+	sampledOut      int // points of the series dropped by Config.SampleRatio
+	invalidLines    int
+	outOfRange      int
+	filteredLines   int
+	fieldEdits      map[string]fieldEdit
+	emptyLines      int // left without fields by Config.DropFields
+	droppedLines    int // skipped by Config.LineTransform
+	commentLines    int
+	totalLines      int // counted by Config.CountLinesFirst, guarded by mu
+	lastWrite       time.Time
+	blankLines      int
+
+	// err is set when the import must stop early.
+	err               error
+	failuresErr       error // set under mu once Config.MaxFailures is exceeded
+	totalInserts      int
+	failedInserts     int
+	failedTransient   int
+	writeRequests     int
+	fullBatches       int
+	partialBatches    int
+	totalCommands     int
+	accumulated       int      // points batched, for progress reports
+	limiter           *limiter // guarded by mu, as SetPPS may replace it
+	throughputStart   time.Time
+	pointsBySecond    []int // inserted in each second since throughputStart
+	throttleWait      time.Duration
+	done              <-chan struct{} // closed when the import is canceled
+	duplicatePoints   int             // removed by resolveDuplicates
+	manifest          *seriesManifest
+	checkpoint        *checkpoint
+	deadLetters       *deadLetterWriter
+	failedLines       *failedLinesWriter
+	resumedInserts    int
+	schema            map[string]map[string]struct{}
+	schemaViolations  map[string]int
+	executedDDL       map[string]struct{}
+	dedupedDDL        int
+	kafka             Sink
+	results           *json.Encoder
+	reversed          []timedLine
+	reversedWindow    int64 // the SortWindow of the lines in reversed
+	errorWindow       time.Time
+	errorsLogged      int // in the current errorWindow
+	errorsSuppressed  int // in the current errorWindow
+	totalSuppressed   int
+	deferDDL          bool // queue DDL for runDeferredDDL
+	deferredDDL       []string
+	measurement       string
+	measurementPoints map[string]int
+	lastLoadCheck     time.Time
+	loadWaits         int
+	retentionPolicies map[string]map[string]struct{} // by database
+	createdDatabases  map[string]bool
+	skippedCreateDB   int
+	org               string
+	bucket            string
+	buckets           map[string]bool // resolved "org/bucket" targets
+	createdRPs        []string
+}
+
+// Stats counts the work done by an import.
+type Stats struct {
+	Commands int
+	Inserts  int
+	Failed   int
+
+	// FailedTransient counts the failed inserts that may succeed when
+	// retried, such as those that failed with a network or server error.
+	// The rest of Failed was rejected outright.
+	FailedTransient int
+
+	DedupedDDL int
+
+	// WriteRequests counts every write sent, including retries. The
+	// batches written are either full, holding Config.BatchSize points or
+	// Config.MaxBatchBytes bytes, or partial, flushed early at the end of a
+	// context or the dump.
+	WriteRequests  int
+	FullBatches    int
+	PartialBatches int
+
+	// Series counts the distinct series seen when Config.SeriesManifestPath
+	// is set.
+	Series int
+
+	// Filtered counts the points skipped by Config.IncludeMeasurements and
+	// Config.ExcludeMeasurements. They are neither inserted nor failed.
+	Filtered int
+}
+
+// Stats returns the work done so far. It may be called while an import is
+// running to report its progress.
+func (i *Importer) Stats() Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return Stats{
+		Commands:        i.totalCommands,
+		Inserts:         i.totalInserts,
+		Failed:          i.failedInserts,
+		FailedTransient: i.failedTransient,
+		DedupedDDL:      i.dedupedDDL,
+		WriteRequests:   i.writeRequests,
+		FullBatches:     i.fullBatches,
+		PartialBatches:  i.partialBatches,
+		Series:          i.seriesCount(),
+		Filtered:        i.filteredLines,
+	}
+}
+
+// seriesCount returns the number of series written to the series manifest.
+func (i *Importer) seriesCount() int {
+	if i.manifest == nil {
+		return 0
+	}
+	return i.manifest.Len()
+}
+
+// NewImporter will return an intialized Importer struct. Unless the embedded
+// client config sets a UserAgent, requests are sent with the User-Agent
+// "influxDB importer/" followed by Config.Version.
+func NewImporter(config Config) *Importer {
+	if config.UserAgent == "" {
+		config.UserAgent = fmt.Sprintf("influxDB importer/%s", config.Version)
+	}
+	if config.RunIDTag != "" && config.RunID == "" {
+		config.RunID = newRunID()
+	}
+	return &Importer{
+		config: config,
+	}
+}
+
+// RunID returns the run ID added to every point, or "" if Config.RunIDTag
+// is not set.
+func (i *Importer) RunID() string {
+	if i.config.RunIDTag == "" {
+		return ""
+	}
+	return i.config.RunID
+}
+
+// newRunID returns a random run ID.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ImportResult summarizes a finished import. It is returned even when the
+// import failed, covering the work done until then.
+type ImportResult struct {
+	TotalCommands   int
+	TotalInserts    int
+	FailedInserts   int
+	Duration        time.Duration
+	PointsPerSecond float64 // of TotalInserts over Duration
+
+	// Skipped counts the points deliberately not written: invalid lines,
+	// points outside the time range, of filtered measurements, of series
+	// sampled out, rejected by the schema, left without fields or dropped
+	// by LineTransform, duplicates removed by DuplicateStrategy, and
+	// points written by an earlier run.
+	Skipped int
+
+	// CommentLines and BlankLines count the lines of the dump holding no
+	// data. Comment lines include the section and context headers.
+	CommentLines int
+	BlankLines   int
+
+	// PointsBySecond holds the points inserted in each second of the
+	// import, including seconds without any, to show stalls and bursts.
+	// MinPPS, MedianPPS and MaxPPS summarize it.
+	PointsBySecond []int
+	MinPPS         int
+	MedianPPS      int
+	MaxPPS         int
+}
+
+// Import processes the specified file in the Config and writes the data to the databases in chunks specified by Config.BatchSize
+func (i *Importer) Import() (ImportResult, error) {
+	return i.ImportContext(context.Background())
+}
+
+// ImportContext is like Import but stops when ctx is canceled, returning
+// ctx.Err(). Cancellation is checked before every line of the dump:
+//
+//   - Canceled during DDL, the import stops before any DML is processed.
+//   - Canceled during DML, the lines batched so far are written if
+//     Config.FlushOnCancel is set and discarded otherwise.
+//   - Canceled while a batch waits for the PPS limit or for a busy server
+//     to settle, the wait ends at once and the batch is handled like the
+//     lines batched so far.
+//
+// A write or query that is already in flight is not interrupted.
+func (i *Importer) ImportContext(ctx context.Context) (ImportResult, error) {
+	i.running.Lock()
+	defer i.running.Unlock()
+	i.reset()
+	start := time.Now()
+	i.mu.Lock()
+	i.throughputStart = start
+	i.mu.Unlock()
+	err := i.importContext(ctx)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	result := ImportResult{
+		TotalCommands:  i.totalCommands,
+		TotalInserts:   i.totalInserts,
+		FailedInserts:  i.failedInserts,
+		Duration:       time.Since(start),
+		PointsBySecond: i.pointsBySecond,
+		Skipped:        i.skipped(),
+		CommentLines:   i.commentLines,
+		BlankLines:     i.blankLines,
+	}
+	if result.Duration > 0 {
+		result.PointsPerSecond = float64(result.TotalInserts) / result.Duration.Seconds()
+	}
+	if n := len(result.PointsBySecond); n > 0 {
+		sorted := append([]int(nil), result.PointsBySecond...)
+		sort.Ints(sorted)
+		result.MinPPS, result.MedianPPS, result.MaxPPS = sorted[0], sorted[n/2], sorted[n-1]
+	}
+	if i.config.SummaryFormat == "json" {
+		if e := i.writeJSONSummary(result, err); e != nil {
+			i.logErrorf("error writing summary: %s\n", e)
+		}
+	}
+	return result, err
+}
+
+// skipped returns the number of points deliberately not written, as
+// reported by ImportResult.Skipped.
+func (i *Importer) skipped() int {
+	n := i.invalidLines + i.outOfRange + i.filteredLines + i.sampledOut + i.emptyLines + i.droppedLines + i.duplicatePoints + i.resumedInserts
+	if !i.config.SchemaWarnOnly {
+		for _, violations := range i.schemaViolations {
+			n += violations
+		}
+	}
+	return n
+}
+
+// writeJSONSummary writes result and err to Config.SummaryWriter as JSON.
+func (i *Importer) writeJSONSummary(result ImportResult, err error) error {
+	summary := struct {
+		TotalCommands   int     `json:"total_commands"`
+		TotalInserts    int     `json:"total_inserts"`
+		FailedInserts   int     `json:"failed_inserts"`
+		Skipped         int     `json:"skipped"`
+		CommentLines    int     `json:"comment_lines"`
+		BlankLines      int     `json:"blank_lines"`
+		ElapsedSeconds  float64 `json:"elapsed_seconds"`
+		PointsPerSecond float64 `json:"pps"`
+		Error           string  `json:"error,omitempty"`
+	}{
+		TotalCommands:   result.TotalCommands,
+		TotalInserts:    result.TotalInserts,
+		FailedInserts:   result.FailedInserts,
+		Skipped:         result.Skipped,
+		CommentLines:    result.CommentLines,
+		BlankLines:      result.BlankLines,
+		ElapsedSeconds:  result.Duration.Seconds(),
+		PointsPerSecond: result.PointsPerSecond,
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	w := i.config.SummaryWriter
+	if w == nil {
+		w = os.Stderr
+	}
+	return json.NewEncoder(w).Encode(summary)
+}
+
+func (i *Importer) importContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Validate args
+	if i.config.Path == "" && i.config.Reader == nil && len(i.config.SplitPaths) == 0 {
+		return ErrFileRequired
+	}
+	if err := i.validate(); err != nil {
+		return err
+	}
+
+	i.done = ctx.Done()
+	if err := i.connect(); err != nil {
+		return err
+	}
+	defer i.close()
+
+	defer i.logSummary()
+	if err := i.setup(); err != nil {
+		return err
+	}
+
+	if i.config.CountLinesFirst {
+		if err := i.countLines(); err != nil {
+			return err
+		}
+	}
+
+	scanner, closeFile, err := i.openInput()
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+	if err := i.importScanner(ctx, scanner, i.inputName()); err != nil {
+		return err
+	}
+	if err := insertError(i.failedInserts); err != nil {
+		return err
+	}
+	return i.postImport()
+}
+
+// logSummary logs the totals of the import.
+func (i *Importer) logSummary() {
+	i.pending.Wait()
+	if i.totalInserts > 0 {
+		i.logf("Processed %d commands\n", i.totalCommands)
+		i.logf("Processed %d inserts\n", i.totalInserts)
+		i.logf("Failed %d inserts\n", i.failedInserts)
+	}
+	if i.config.RunIDTag != "" {
+		i.logf("Tagged points with %s=%s\n", i.config.RunIDTag, i.config.RunID)
+	}
+	if batches := i.fullBatches + i.partialBatches; batches > 0 {
+		i.logf("Made %d write requests for %d batches of %.1f points on average\n", i.writeRequests, batches, float64(i.totalInserts+i.failedInserts)/float64(batches))
+		i.logf("Wrote %d full batches and %d partial batches\n", i.fullBatches, i.partialBatches)
+	}
+	if i.failedInserts > 0 {
+		i.logf("Failed %d inserts with transient errors, re-running may insert them\n", i.failedTransient)
+		i.logf("Failed %d inserts with permanent errors, re-running will not insert them\n", i.failedInserts-i.failedTransient)
+	}
+	if i.totalSuppressed > 0 {
+		i.logf("Suppressed %d error messages\n", i.totalSuppressed)
+	}
+	if i.dedupedDDL > 0 {
+		i.logf("Skipped %d duplicate DDL statements\n", i.dedupedDDL)
+	}
+	if i.skippedCreateDB > 0 {
+		i.logf("Skipped %d CREATE DATABASE statements\n", i.skippedCreateDB)
+	}
+	if i.manifest != nil {
+		i.logf("Saw %d distinct series\n", i.manifest.Len())
+	}
+	if i.duplicatePoints > 0 {
+		i.logf("Resolved %d duplicate points with the %q strategy\n", i.duplicatePoints, i.config.DuplicateStrategy)
+	}
+	if i.invalidLines > 0 {
+		i.logf("Skipped %d invalid lines\n", i.invalidLines)
+	}
+	if i.outOfRange > 0 {
+		i.logf("Skipped %d points outside the time range\n", i.outOfRange)
+	}
+	if i.filteredLines > 0 {
+		i.logf("Skipped %d points of filtered measurements\n", i.filteredLines)
+	}
+	if i.emptyLines > 0 {
+		i.logf("Skipped %d points left without fields\n", i.emptyLines)
+	}
+	if i.droppedLines > 0 {
+		i.logf("Skipped %d points dropped by the line transform\n", i.droppedLines)
+	}
+	if i.commentLines > 0 || i.blankLines > 0 {
+		i.logf("Skipped %d comment lines and %d blank lines\n", i.commentLines, i.blankLines)
+	}
+	for _, rp := range i.createdRPs {
+		i.logf("Created retention policy %s\n", rp)
+	}
+	if i.throttleWait > 0 {
+		i.logf("Waited %s for the points per second limit\n", i.throttleWait)
+	}
+	if i.loadWaits > 0 {
+		i.logf("Waited %d times for the server load to drop\n", i.loadWaits)
+	}
+	if i.rateLimitWaits > 0 {
+		i.logf("Waited %d times for the server rate limit\n", i.rateLimitWaits)
+	}
+	if i.downgraded > 0 {
+		i.logf("Wrote %d points at consistency one after partial writes at consistency %s\n", i.downgraded, i.config.WriteConsistency)
+	}
+	if i.sampledSeries != nil {
+		var kept int
+		for _, keep := range i.sampledSeries {
+			if keep {
+				kept++
+			}
+		}
+		i.logf("Sampled %d series, dropped %d series\n", kept, len(i.sampledSeries)-kept)
+	}
+	if i.resumedInserts > 0 {
+		i.logf("Skipped %d inserts already written by a previous run\n", i.resumedInserts)
+	}
+	for n, count := range i.repaired {
+		if count > 0 {
+			i.logf("Repaired %d lines: %s\n", count, repairs[n].name)
+		}
+	}
+	for name, n := range i.schemaViolations {
+		i.logf("Found %d schema violations for measurement %q\n", n, name)
+	}
+}
+
+// setup prepares the optional features enabled in the config.
+func (i *Importer) setup() error {
+	switch i.config.DuplicateStrategy {
+	case "", "last", "first", "sum", "skip":
+	default:
+		return fmt.Errorf("unknown duplicate strategy %q", i.config.DuplicateStrategy)
+	}
+	if f := i.config.CompressionFormat; f < CompressionAuto || f > CompressionZstd {
+		return fmt.Errorf("unknown compression format %d", f)
+	}
+	for _, patterns := range [][]string{i.config.IncludeMeasurements, i.config.ExcludeMeasurements} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid measurement pattern %q: %s", pattern, err)
+			}
+		}
+	}
+
+	// Start the workers writing batches concurrently
+	if i.config.Concurrency > 1 {
+		i.jobs = make(chan *batch)
+		for n := 0; n < i.config.Concurrency; n++ {
+			go i.worker(i.jobs)
+		}
+	}
+
+	i.setupTransforms()
+
+	if i.config.ResultsWriter != nil {
+		i.results = json.NewEncoder(i.config.ResultsWriter)
+	}
+
+	if i.config.MeasurementProgress {
+		i.measurementPoints = make(map[string]int)
+	}
+
+	if i.config.ReverseTime && i.config.SortWindow > 0 {
+		i.logf("Buffering points in windows of %s to write them in reverse chronological order\n", i.config.SortWindow)
+	} else if i.config.ReverseTime {
+		i.logln("Buffering points in memory to write them in reverse chronological order")
+	}
+
+	// Load the hashes of batches written by previous runs
+	if i.config.CheckpointPath != "" {
+		cp, err := openCheckpoint(i.config.CheckpointPath, i.config.Resume)
+		if err != nil {
+			return err
+		}
+		i.checkpoint = cp
+	}
+
+	// Open the dead-letter file for failed lines
+	if i.config.DeadLetterPath != "" {
+		dl, err := createDeadLetterWriter(i.config.DeadLetterPath)
+		if err != nil {
+			return err
+		}
+		i.deadLetters = dl
+	}
+
+	// Open the file failed lines are appended to
+	if i.config.FailedLinesPath != "" {
+		fl, err := openFailedLinesWriter(i.config.FailedLinesPath)
+		if err != nil {
+			return err
+		}
+		i.failedLines = fl
+	}
+
+	// Create the series manifest
+	if i.config.SeriesManifestPath != "" {
+		m, err := createSeriesManifest(i.config.SeriesManifestPath)
+		if err != nil {
+			return err
+		}
+		i.mu.Lock()
+		i.manifest = m
+		i.mu.Unlock()
+	}
+	return nil
+}
+
+// setupTransforms prepares the state used by transform.
+func (i *Importer) setupTransforms() {
+	if i.config.SampleRatio > 0 && i.config.SampleRatio < 1 {
+		i.sampledSeries = make(map[uint64]bool)
+	}
+
+	// Set up counters for the enabled line repairs
+	for _, r := range repairs {
+		if r.enabled(&i.config) {
+			i.repaired = make([]int, len(repairs))
+			break
+		}
+	}
+
+	// Index the fields to drop or coerce
+	for _, edit := range []struct {
+		keys []string
+		edit fieldEdit
+	}{
+		{i.config.DropFields, dropField},
+		{i.config.CoerceFloatFields, floatField},
+		{i.config.CoerceIntFields, intField},
+	} {
+		for _, k := range edit.keys {
+			if i.fieldEdits == nil {
+				i.fieldEdits = make(map[string]fieldEdit)
+			}
+			i.fieldEdits[k] = edit.edit
+		}
+	}
+
+	// Index the expected field keys of each measurement
+	if len(i.config.Schema) > 0 {
+		i.schema = make(map[string]map[string]struct{}, len(i.config.Schema))
+		i.schemaViolations = make(map[string]int)
+		for name, keys := range i.config.Schema {
+			i.schema[name] = make(map[string]struct{}, len(keys))
+			for _, k := range keys {
+				i.schema[name][k] = struct{}{}
+			}
+		}
+	}
+}
+
+// importFile processes the dump at path.
+func (i *Importer) importFile(ctx context.Context, path string) error {
+	scanner, closeFile, err := i.openFile(path)
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+	return i.importScanner(ctx, scanner, path)
+}
+
+// importScanner processes the dump read by scanner. name is the dump as
+// given in errors reading it.
+func (i *Importer) importScanner(ctx context.Context, s *bufio.Scanner, name string) error {
+	i.lineNum = 0
+
+	var scanner lineScanner = s
+	if i.config.FlushInterval > 0 {
+		deadline := func() time.Time { return i.lastWrite.Add(i.config.FlushInterval) }
+		fs := newFlushScanner(s, deadline, i.flushIdle, ctx.Done())
+		defer fs.stop()
+		scanner = fs
+	}
+
+	// Process the DDL
+	if err := i.processDDL(ctx, scanner); err != nil {
+		return err
+	}
+	if i.err != nil {
+		return i.err
+	}
+
+	i.startLimiter()
+
+	// Process the DML
+	if err := i.processDML(ctx, scanner); err != nil {
+		return err
+	}
+
+	// Check if we had any errors scanning the file
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %s", name, err)
+	}
+	return nil
+}
+
+// openInput opens Config.Reader, or else the dump at Config.Path, or else
+// the dump split across Config.SplitPaths, for scanning. The returned function
+// closes it.
+func (i *Importer) openInput() (*bufio.Scanner, func(), error) {
+	if i.config.Reader != nil {
+		return i.openReader(i.config.Reader, func() {})
+	}
+	if i.config.Path == "" && len(i.config.SplitPaths) > 0 {
+		paths, err := expandPaths(i.config.SplitPaths)
+		if err != nil {
+			return nil, nil, err
+		}
+		r := &multiFileReader{i: i, paths: paths}
+		return bufio.NewScanner(r), r.close, nil
+	}
+	return i.openFile(i.config.Path)
+}
+
+// inputName names the dump opened by openInput for error messages.
+func (i *Importer) inputName() string {
+	switch {
+	case i.config.Reader != nil:
+		return "Config.Reader"
+	case i.config.Path == "" && len(i.config.SplitPaths) > 0:
+		return "split dump"
+	case i.config.Path == "-":
+		return "standard input"
+	}
+	return i.config.Path
+}
+
+// countLines counts the DML lines of the dump at Config.Path for
+// Config.CountLinesFirst.
+func (i *Importer) countLines() error {
+	path := i.config.Path
+	if i.config.Reader != nil || path == "" || path == "-" || isURL(path) {
+		i.logf("Not counting the lines of a dump that can only be read once\n")
+		return nil
+	}
+	scanner, closeFile, err := i.openFile(path)
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	var n int
+	var dml bool
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# DML"):
+			dml = true
+		case strings.HasPrefix(line, "# DDL"):
+			dml = false
+		case dml && !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "":
+			n++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("counting lines: %s", err)
+	}
+	i.mu.Lock()
+	i.totalLines = n
+	i.mu.Unlock()
+	i.logf("Counted %d lines to import\n", n)
+	return nil
+}
+
+// TotalLines returns the number of DML lines counted by
+// Config.CountLinesFirst, or 0 if they were not counted. ProgressFunc may
+// use it to work out a percentage.
+func (i *Importer) TotalLines() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.totalLines
+}
+
+// openFile opens the dump at path, or standard input if path is "-", for
+// scanning. The returned function closes it.
+func (i *Importer) openFile(path string) (*bufio.Scanner, func(), error) {
+	if path == "-" {
+		return i.openReader(os.Stdin, func() {})
+	}
+
+	f, err := i.openPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return i.openReader(f, func() { f.Close() })
+}
+
+// openPath opens the file at path, or requests it if path is an http or
+// https URL.
+func (i *Importer) openPath(path string) (io.ReadCloser, error) {
+	if !isURL(path) {
+		return os.Open(path)
+	}
+
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if i.config.PathBearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+i.config.PathBearerToken)
+	} else if i.config.PathUsername != "" {
+		req.SetBasicAuth(i.config.PathUsername, i.config.PathPassword)
+	}
+	resp, err := i.pathClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not download %s: %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// pathClient returns the HTTP client that downloads a dump given as a URL,
+// with the TLS settings of the client config. Config.WriteTimeout, or else
+// the client timeout, limits connecting and waiting for the response but
+// not the download itself, as a large dump takes a while.
+func (i *Importer) pathClient() *http.Client {
+	tlsConfig := i.config.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: i.config.UnsafeSsl,
+		}
+	}
+	timeout := i.config.Timeout
+	if i.config.WriteTimeout > 0 {
+		timeout = i.config.WriteTimeout
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+}
+
+// isURL returns whether path is an http or https URL.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// openReader prepares f for scanning. The returned function calls closeFile
+// after releasing anything opened on top of f.
+func (i *Importer) openReader(f io.Reader, closeFile func()) (*bufio.Scanner, func(), error) {
+	r, closeAll, err := i.decompress(f, closeFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewScanner(r), closeAll, nil
+}
+
+// decompress returns the text of f, decompressing it if necessary. The
+// returned function calls closeFile after releasing anything opened on top
+// of f.
+func (i *Importer) decompress(f io.Reader, closeFile func()) (*bufio.Reader, func(), error) {
+	r := bufio.NewReader(f)
+	closeAll := closeFile
+
+	format := i.config.CompressionFormat
+	if format == CompressionAuto && i.config.Compressed {
+		format = CompressionGzip
+	}
+	if format == CompressionAuto {
+		magic, _ := r.Peek(4)
+		switch {
+		case bytes.HasPrefix(magic, gzipMagic):
+			format = CompressionGzip
+		case bytes.HasPrefix(magic, bzip2Magic):
+			format = CompressionBzip2
+		case bytes.HasPrefix(magic, zstdMagic):
+			format = CompressionZstd
+		}
+	}
+
+	switch format {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			closeFile()
+			return nil, nil, err
+		}
+		closeAll = func() {
+			gr.Close()
+			closeFile()
+		}
+		// Read every member of dumps made by concatenating gzip files
+		gr.Multistream(true)
+		r = bufio.NewReader(gr)
+	case CompressionBzip2:
+		r = bufio.NewReader(bzip2.NewReader(r))
+	case CompressionZstd:
+		r = bufio.NewReader(zstd.NewReader(r))
+	}
+
+	// Refuse binary files rather than failing every line of them
+	if err := checkText(r); err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return r, closeAll, nil
+}
+
+// postImport runs Config.PostImportQueries, logging their results.
+func (i *Importer) postImport() error {
+	if len(i.config.PostImportQueries) > 0 && i.client == nil {
+		i.logln("skipping post-import queries, there is no server to run them on")
+		return nil
+	}
+	for _, q := range i.config.PostImportQueries {
+		resp, err := i.client.Query(client.Query{Command: q})
+		if err == nil {
+			err = resp.Error()
+		}
+		if err != nil {
+			i.logf("post-import query %q failed: %s\n", q, err)
+			if i.config.PostImportFailOnError {
+				return fmt.Errorf("post-import query %q failed: %s", q, err)
+			}
+			continue
+		}
+
+		var rows int
+		for _, r := range resp.Results {
+			for _, row := range r.Series {
+				rows += len(row.Values)
+			}
+		}
+		i.logf("post-import query %q returned %d rows\n", q, rows)
+	}
+	return nil
+}
+
+// validate returns an error for write options the server would reject, so
+// that they are reported before anything is imported.
+func (i *Importer) validate() error {
+	if err := validatePrecision(i.config.Precision); err != nil {
+		return err
+	}
+	for _, p := range i.config.MeasurementPrecision {
+		if err := validatePrecision(p); err != nil {
+			return err
+		}
+	}
+	switch i.config.WriteConsistency {
+	case "", "any", "one", "quorum", "all":
+	default:
+		return fmt.Errorf("invalid write consistency %q, expected any, one, quorum or all", i.config.WriteConsistency)
+	}
+	switch i.config.SummaryFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid summary format %q, expected text or json", i.config.SummaryFormat)
+	}
+	return nil
+}
+
+// validatePrecision returns an error if p is not a precision accepted for
+// writes.
+func validatePrecision(p string) error {
+	switch p {
+	case "", "n", "ns", "u", "ms", "s", "m", "h":
+		return nil
+	}
+	return fmt.Errorf("invalid precision %q, expected ns, u, ms, s, m or h", p)
+}
+
+// connect creates a client and tries to connect, unless points go to a sink.
+func (i *Importer) connect() error {
+	if i.config.Sink == nil && len(i.config.KafkaBrokers) > 0 {
+		if newKafkaSink == nil {
+			return errors.New("kafka support is not compiled in, rebuild with -tags kafka")
+		}
+		sink, err := newKafkaSink(i.config.KafkaBrokers, i.config.KafkaTopic)
+		if err != nil {
+			return fmt.Errorf("could not connect to kafka: %s", err)
+		}
+		i.config.Sink = sink
+		i.kafka = sink
+	}
+	if i.config.Sink != nil {
+		return nil
+	}
+	if i.config.Client != nil {
+		i.client = i.config.Client
+	} else {
+		cfg := i.config.Config
+		if i.config.WriteTimeout > 0 {
+			cfg.Timeout = i.config.WriteTimeout
+		}
+		cl, err := client.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("could not create client %s", err)
+		}
+		i.client = cl
+	}
+	if _, _, err := i.client.Ping(); err != nil {
+		return &ConnectionError{Addr: i.client.Addr(), Err: err}
+	}
+	return nil
+}
+
+// Close releases the client and rate limiter held on to after an import.
+// If an import is running, for instance one just canceled that is still
+// writing its last batches, Close first waits for it to return. The
+// importer can still be used again afterwards.
+func (i *Importer) Close() error {
+	i.running.Lock()
+	defer i.running.Unlock()
+	i.mu.Lock()
+	i.limiter = nil
+	i.mu.Unlock()
+	i.client = nil
+	return nil
+}
+
+// reset clears the counters and state left by a previous import, so that
+// every import starts afresh.
+func (i *Importer) reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.kafka != nil {
+		// The sink opened by connect was closed with the last import.
+		i.config.Sink = nil
+	}
+	i.importState = importState{}
+}
+
+// SetPPS changes the points per second limit, zero or less meaning no
+// limit. It may be called while an import is running, and the new limit
+// applies from the next batch on.
+func (i *Importer) SetPPS(pps int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.config.PPS = pps
+	switch {
+	case pps <= 0:
+		i.limiter = nil
+	case i.limiter == nil:
+		i.limiter = newLimiter(pps, i.batchSize())
+	default:
+		i.limiter.setRate(pps, time.Now())
+	}
+}
+
+// startLimiter starts limiting writes to Config.PPS.
+func (i *Importer) startLimiter() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.limiter = newLimiter(i.config.PPS, i.batchSize())
+}
+
+// close releases the files opened by setup and the sink opened by connect.
+func (i *Importer) close() {
+	if i.jobs != nil {
+		i.pending.Wait()
+		close(i.jobs)
+		i.jobs = nil
+	}
+	if i.checkpoint != nil {
+		i.checkpoint.Close()
+	}
+	if i.deadLetters != nil {
+		if err := i.deadLetters.Close(); err != nil {
+			i.logf("error: %s\n", err)
+		}
+	}
+	if i.failedLines != nil {
+		if err := i.failedLines.Close(); err != nil {
+			i.logf("error: %s\n", err)
+		}
+	}
+	if i.kafka != nil {
+		if err := i.kafka.Close(); err != nil {
+			i.logf("error: %s\n", err)
+		}
+	}
+	if i.manifest != nil {
+		if err := i.manifest.Close(); err != nil {
+			i.logf("error: %s\n", err)
+		}
+	}
+}
+
+// checkText returns an error if the start of r is not UTF-8 text without
+// null bytes. Nothing is consumed from r, and no more than is already
+// buffered is checked, so that a slow stream is not waited on.
+func checkText(r *bufio.Reader) error {
+	r.Peek(1)
+	n := r.Buffered()
+	if n > 512 {
+		n = 512
+	}
+	chunk, _ := r.Peek(n)
+	for len(chunk) > 0 {
+		c, size := utf8.DecodeRune(chunk)
+		if c == 0 || (c == utf8.RuneError && size == 1 && utf8.FullRune(chunk)) {
+			return errors.New("input does not appear to be a text dump")
+		}
+		if c == utf8.RuneError && size == 1 {
+			break // a rune cut off at the end of the chunk
+		}
+		chunk = chunk[size:]
+	}
+	return nil
+}
+
+// insertError returns an error if there were any failed inserts so that a
+// non-zero exit code can be returned.
+func insertError(failed int) error {
+	if failed > 0 {
+		plural := " was"
+		if failed > 1 {
+			plural = "s were"
+		}
+
+		return fmt.Errorf("%d point%s not inserted", failed, plural)
+	}
+	return nil
+}
+
+func (i *Importer) processDDL(ctx context.Context, scanner lineScanner) error {
+	if i.config.DDLConcurrency > 1 && i.config.Sink == nil {
+		i.deferDDL = true
+		defer i.runDeferredDDL()
+	}
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i.lineNum++
+		line := scanner.Text()
+		// If we find the DML token, we are done with DDL
+		if strings.HasPrefix(line, "# DML") {
+			i.commentLines++
+			return nil
+		}
+		if strings.HasPrefix(line, "#") {
+			i.commentLines++
+			i.detectVersion(line)
+			continue
+		}
+		// Skip blank lines
+		if strings.TrimSpace(line) == "" {
+			i.blankLines++
+			continue
+		}
+		i.queryExecutor(line)
+		if i.err != nil {
+			return i.err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if scanner.Err() != nil {
+		// Reported once the DML has been processed
+		return nil
+	}
+	if i.config.RequireDML {
+		return ErrNoDML
+	}
+	i.logf("warning: dump has no # DML section, no points were imported\n")
+	return nil
+}
+
+// detectVersion records the InfluxDB version the dump was exported from if
+// the comment line names it, warning when it is not a 0.8 dump.
+func (i *Importer) detectVersion(line string) {
+	m := versionRegexp.FindStringSubmatch(line)
+	if m == nil || i.dumpVersion != "" {
+		return
+	}
+	i.dumpVersion = m[1]
+	if !strings.HasPrefix(i.dumpVersion, "0.8.") {
+		i.logf("warning: dump was exported from InfluxDB %s but this importer expects a 0.8 dump\n", i.dumpVersion)
+	}
+}
+
+// DumpVersion returns the InfluxDB version named in the header of the dump,
+// or an empty string if the header did not name one.
+func (i *Importer) DumpVersion() string {
+	return i.dumpVersion
+}
+
+func (i *Importer) processDML(ctx context.Context, scanner lineScanner) error {
+	start := time.Now()
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			if i.config.FlushOnCancel {
+				i.flush()
+			}
+			return err
+		}
+		i.lineNum++
+		line := scanner.Text()
+		// Another dump concatenated to this one starts with its own DDL
+		if strings.HasPrefix(line, "# DDL") {
+			i.commentLines++
+			i.flush()
+			if err := i.processDDL(ctx, scanner); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "# CONTEXT-DATABASE:") {
+			i.setContext(strings.TrimSpace(strings.SplitN(line, ":", 2)[1]), i.retentionPolicy)
+		}
+		if strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:") {
+			i.setContext(i.database, strings.TrimSpace(strings.SplitN(line, ":", 2)[1]))
+		}
+		if strings.HasPrefix(line, "#") {
+			i.commentLines++
+			continue
+		}
+		// Skip blank lines
+		if strings.TrimSpace(line) == "" {
+			i.blankLines++
+			continue
+		}
+		if len(i.config.LineRanges) > 0 && !i.inLineRanges(i.lineNum) {
+			continue
+		}
+		if i.contextChanged {
+			i.contextChanged = false
+			i.ensureDatabase(i.database)
+			if i.config.CreateRetentionPolicies && i.client != nil {
+				i.ensureRetentionPolicy()
+			}
+			if fn := i.config.OnContextChange; fn != nil {
+				fn(i.database, i.retentionPolicy)
+			}
+		}
+		i.batchAccumulator(line, start)
+		if i.err != nil {
+			return i.err
+		}
+		if err := i.tooManyFailures(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil && !i.config.FlushOnCancel {
+		return err
+	}
+	// Flush one last time to write anything out in the batch
+	i.flush()
+	i.finalProgress(start)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := i.tooManyFailures(); err != nil {
+		return err
+	}
+	return i.err
+}
 
-	client.Config
+// tooManyFailures returns an error once more than Config.MaxFailures inserts
+// have failed.
+func (i *Importer) tooManyFailures() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.failuresErr
 }
 
-// NewConfig returns an initialized *Config
-func NewConfig() Config {
-	return Config{Config: client.NewConfig()}
+// inLineRanges returns true if line number n is in one of Config.LineRanges.
+func (i *Importer) inLineRanges(n int) bool {
+	for _, r := range i.config.LineRanges {
+		if n >= r.Start && n <= r.End {
+			return true
+		}
+	}
+	return false
 }
 
-// Importer is the importer used for importing 0.8 data
-type Importer struct {
-	client                *client.Client
-	database              string
-	retentionPolicy       string
-	config                Config
-	batch                 []string
-	totalInserts          int
-	failedInserts         int
-	totalCommands         int
-	throttlePointsWritten int
-	lastWrite             time.Time
-	throttle              *time.Ticker
-}
-
-// NewImporter will return an intialized Importer struct
-func NewImporter(config Config) *Importer {
-	config.UserAgent = fmt.Sprintf("influxDB importer/%s", config.Version)
-	return &Importer{
-		config: config,
-		batch:  make([]string, 0, batchSize),
+// setContext switches the database and retention policy that lines are
+// written to, first flushing the lines batched for the previous context.
+func (i *Importer) setContext(database, retentionPolicy string) {
+	if database == i.database && retentionPolicy == i.retentionPolicy {
+		return
+	}
+	i.flush()
+	i.database, i.retentionPolicy = database, retentionPolicy
+	i.contextChanged = true
+
+	if fn := i.config.BucketFunc; fn != nil {
+		i.org, i.bucket = fn(database, retentionPolicy)
+		if target := i.org + "/" + i.bucket; !i.buckets[target] {
+			i.logf("writing %s to bucket %q of organization %q\n", influxql.QuoteIdent(database, retentionPolicy), i.bucket, i.org)
+			if i.buckets == nil {
+				i.buckets = make(map[string]bool)
+			}
+			i.buckets[target] = true
+		}
 	}
 }
 
-// Import processes the specified file in the Config and writes the data to the databases in chunks specified by batchSize
-func (i *Importer) Import() error {
-	// Create a client and try to connect.
-	cl, err := client.NewClient(i.config.Config)
-	if err != nil {
-		return fmt.Errorf("could not create client %s", err)
+// ensureDatabase creates database the first time it is seen, unless the
+// DDL of the dump created it already.
+func (i *Importer) ensureDatabase(database string) {
+	if database == "" || i.createdDatabases[database] || i.config.SkipDatabaseCreation {
+		return
 	}
-	i.client = cl
-	if _, _, e := i.client.Ping(); e != nil {
-		return fmt.Errorf("failed to connect to %s\n", i.client.Addr())
+	i.queryExecutor("CREATE DATABASE " + influxql.QuoteIdent(database))
+	if i.createdDatabases == nil {
+		i.createdDatabases = make(map[string]bool)
 	}
+	i.createdDatabases[database] = true
+}
 
-	// Validate args
-	if i.config.Path == "" {
-		return fmt.Errorf("file argument required")
+// ensureRetentionPolicy creates the retention policy of the current context
+// if the database does not have it yet.
+func (i *Importer) ensureRetentionPolicy() {
+	if i.database == "" || i.retentionPolicy == "" {
+		return
+	}
+	existing, ok := i.retentionPolicies[i.database]
+	if !ok {
+		var err error
+		if existing, err = i.showRetentionPolicies(i.database); err != nil {
+			i.logErrorf("error: %s\n", err)
+			return
+		}
+		if i.retentionPolicies == nil {
+			i.retentionPolicies = make(map[string]map[string]struct{})
+		}
+		i.retentionPolicies[i.database] = existing
+	}
+	if _, ok := existing[i.retentionPolicy]; ok {
+		return
+	}
+
+	duration := i.config.RetentionPolicyDuration
+	if duration == "" {
+		duration = "INF"
+	}
+	replication := i.config.RetentionPolicyReplication
+	if replication <= 0 {
+		replication = 1
 	}
+	i.queryExecutor(fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION %s REPLICATION %d",
+		influxql.QuoteIdent(i.retentionPolicy), influxql.QuoteIdent(i.database), duration, replication))
+	existing[i.retentionPolicy] = struct{}{}
+	i.createdRPs = append(i.createdRPs, influxql.QuoteIdent(i.database, i.retentionPolicy))
+}
 
-	defer func() {
-		if i.totalInserts > 0 {
-			log.Printf("Processed %d commands\n", i.totalCommands)
-			log.Printf("Processed %d inserts\n", i.totalInserts)
-			log.Printf("Failed %d inserts\n", i.failedInserts)
+// showRetentionPolicies returns the names of the retention policies of database.
+func (i *Importer) showRetentionPolicies(database string) (map[string]struct{}, error) {
+	resp, err := i.client.Query(client.Query{Command: "SHOW RETENTION POLICIES ON " + influxql.QuoteIdent(database)})
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Error(); err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{})
+	for _, r := range resp.Results {
+		for _, row := range r.Series {
+			for n, col := range row.Columns {
+				if col != "name" {
+					continue
+				}
+				for _, v := range row.Values {
+					if name, ok := v[n].(string); ok {
+						names[name] = struct{}{}
+					}
+				}
+			}
 		}
-	}()
+	}
+	return names, nil
+}
+
+func (i *Importer) execute(command string) {
+	if err := i.query(command); err != nil {
+		i.ddlError(command, err)
+	}
+}
+
+// ddlError logs the error of a DDL statement, stopping the import if
+// Config.StopOnDDLError is set.
+func (i *Importer) ddlError(command string, err error) {
+	i.logErrorf("error: %s\n", err)
+	if i.config.StopOnDDLError && i.err == nil {
+		i.err = fmt.Errorf("error executing %q: %s", command, err)
+	}
+}
 
-	// Open the file
-	f, err := os.Open(i.config.Path)
+// query executes command, returning the error of the request or response.
+func (i *Importer) query(command string) error {
+	response, err := i.client.Query(client.Query{Command: command, Database: i.database})
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	return response.Error()
+}
+
+func (i *Importer) queryExecutor(command string) {
+	if i.config.SkipDatabaseCreation && createDatabaseRegexp.MatchString(command) {
+		i.skippedCreateDB++
+		return
+	}
+	if i.config.DedupeDDL {
+		key := strings.Join(strings.Fields(command), " ")
+		if _, ok := i.executedDDL[key]; ok {
+			i.mu.Lock()
+			i.dedupedDDL++
+			i.mu.Unlock()
+			return
+		}
+		if i.executedDDL == nil {
+			i.executedDDL = make(map[string]struct{})
+		}
+		i.executedDDL[key] = struct{}{}
+	}
+	if createDatabaseRegexp.MatchString(command) {
+		if database, ok := ddlDatabase(command); ok {
+			if i.createdDatabases == nil {
+				i.createdDatabases = make(map[string]bool)
+			}
+			i.createdDatabases[database] = true
+		}
+	}
+
+	i.mu.Lock()
+	i.totalCommands++
+	i.mu.Unlock()
+	if i.config.Sink != nil {
+		if sink, ok := i.config.Sink.(DDLSink); ok {
+			if err := sink.ExecuteDDL(command); err != nil {
+				i.ddlError(command, err)
+			}
+		}
+		return
+	}
+	if i.deferDDL {
+		i.deferredDDL = append(i.deferredDDL, command)
+		return
+	}
+	i.execute(command)
+}
 
-	var r io.Reader
+func (i *Importer) batchAccumulator(line string, start time.Time) {
+	precision := i.precisionOf(line)
+	line, ok := i.transform(line, precision)
+	if !ok {
+		return
+	}
+	database, retentionPolicy := i.database, i.retentionPolicy
+	if fn := i.config.RouteFunc; fn != nil {
+		db, rp := fn(line)
+		if db != "" {
+			database = db
+		}
+		if rp != "" {
+			retentionPolicy = rp
+		}
+		i.ensureDatabase(database)
+	}
+	i.accumulate(line, database, retentionPolicy, precision, start)
+}
 
-	// If gzipped, wrap in a gzip reader
-	if i.config.Compressed {
-		gr, err := gzip.NewReader(f)
+// accumulate adds a line that is ready to be written to the batch of its
+// destination, writing the batch once it is full.
+func (i *Importer) accumulate(line, database, retentionPolicy, precision string, start time.Time) {
+	if i.manifest != nil {
+		key, _, _ := splitLine(line)
+		i.mu.Lock()
+		err := i.manifest.add(key)
+		i.mu.Unlock()
 		if err != nil {
-			return err
+			i.logErrorf("error writing series manifest: %s\n", err)
+		}
+	}
+	if i.measurementPoints != nil {
+		key, _, _ := splitLine(line)
+		i.measurement = measurementName(key)
+	}
+	if i.config.ReverseTime {
+		l := newTimedLine(line, precision, i.lineNum)
+		l.database, l.retentionPolicy = database, retentionPolicy
+		if i.config.SortWindow > 0 {
+			w := l.window(i.config.SortWindow)
+			if len(i.reversed) > 0 && w != i.reversedWindow {
+				i.flushReversed()
+			}
+			i.reversedWindow = w
 		}
-		defer gr.Close()
-		// Set the reader to the gzip reader
-		r = gr
+		i.reversed = append(i.reversed, l)
 	} else {
-		// Standard text file so our reader can just be the file
-		r = f
+		i.add(i.batchFor(database, retentionPolicy, precision), line, i.lineNum)
 	}
+	i.accumulated++
+	i.progress(start)
+}
 
-	// Get our reader
-	scanner := bufio.NewScanner(r)
+// progress gives some status feedback every time another interval of points
+// has been batched, whether or not a batch has been written since.
+func (i *Importer) progress(start time.Time) {
+	every := i.progressEvery()
+	if every <= 0 || i.accumulated%every != 0 {
+		return
+	}
+	i.mu.Lock()
+	failed := i.failedInserts
+	measurementPoints := i.measurementPoints[i.measurement]
+	total := i.totalLines
+	i.mu.Unlock()
 
-	// Process the DDL
-	i.processDDL(scanner)
+	processed := i.accumulated
+	since := time.Since(start)
+	pps := float64(processed) / since.Seconds()
+	i.logf("Processed %d lines.  Time elapsed: %s.  Points per second (PPS): %d", processed, since.String(), int64(pps))
+	if total > 0 {
+		i.logf("Processed %.1f%% of %d lines", 100*float64(processed)/float64(total), total)
+	}
+	if i.measurementPoints != nil {
+		i.logf("Measurement %q: %d points written", i.measurement, measurementPoints)
+	}
+	if fn := i.config.ProgressFunc; fn != nil {
+		fn(processed, failed, pps)
+	}
+}
 
-	// Set up our throttle channel.  Since there is effectively no other activity at this point
-	// the smaller resolution gets us much closer to the requested PPS
-	i.throttle = time.NewTicker(time.Microsecond)
-	defer i.throttle.Stop()
+// finalProgress calls Config.ProgressFunc once the DML has been flushed.
+func (i *Importer) finalProgress(start time.Time) {
+	fn := i.config.ProgressFunc
+	if fn == nil {
+		return
+	}
+	i.mu.Lock()
+	failed := i.failedInserts
+	i.mu.Unlock()
+	processed := i.accumulated
+	fn(processed, failed, float64(processed)/time.Since(start).Seconds())
+}
 
-	// Prime the last write
-	i.lastWrite = time.Now()
+// transform repairs, validates, samples, checks and rewrites line as
+// configured. It returns false if the line is not to be written.
+func (i *Importer) transform(line, precision string) (string, bool) {
+	if i.repaired != nil {
+		var ok bool
+		if line, ok = i.repairLine(line, precision); !ok {
+			return "", false
+		}
+	}
+	if i.config.ValidateLines || i.config.StopOnFirstInvalid {
+		if _, err := models.ParsePointsWithPrecision([]byte(line), time.Now().UTC(), precision); err != nil {
+			if i.config.StopOnFirstInvalid {
+				i.err = fmt.Errorf("invalid line %d: %s: %q", i.lineNum, err, line)
+				return "", false
+			}
+			i.logf("skipping invalid line %d: %s\n", i.lineNum, err)
+			i.invalidLines++
+			if i.deadLetters != nil {
+				i.mu.Lock()
+				if err := i.deadLetters.write(0, i.database, i.retentionPolicy, []string{line}, []int{i.lineNum}, err); err != nil {
+					i.logErrorf("error writing dead letters: %s\n", err)
+				}
+				i.mu.Unlock()
+			}
+			return "", false
+		}
+	}
+	if (len(i.config.IncludeMeasurements) > 0 || len(i.config.ExcludeMeasurements) > 0) && !i.matchMeasurement(line) {
+		i.mu.Lock()
+		i.filteredLines++
+		i.mu.Unlock()
+		return "", false
+	}
+	if i.sampledSeries != nil && !i.sample(line) {
+		i.sampledOut++
+		return "", false
+	}
+	if i.schema != nil && !i.checkSchema(line) && !i.config.SchemaWarnOnly {
+		return "", false
+	}
+	if i.fieldEdits != nil {
+		var ok bool
+		if line, ok = editFields(line, i.fieldEdits); !ok {
+			i.emptyLines++
+			return "", false
+		}
+	}
+	if len(i.config.RenameMeasurements) > 0 || len(i.config.RenameTags) > 0 || len(i.config.RenameFields) > 0 {
+		line = i.renameLine(line)
+	}
+	if i.config.NormalizeCase {
+		line = lowerNames(line)
+	}
+	if len(i.config.AddTags) > 0 {
+		line = setTags(line, i.config.AddTags, i.config.OverwriteTags)
+	}
+	if i.config.RunIDTag != "" {
+		line = addTag(line, i.config.RunIDTag, i.config.RunID)
+	}
+	if i.config.TimezoneOffset != 0 {
+		line = shiftTimestamp(line, i.config.TimezoneOffset, precision)
+	}
+	if (!i.config.StartTime.IsZero() || !i.config.EndTime.IsZero()) && !i.inTimeRange(line, precision) {
+		i.outOfRange++
+		return "", false
+	}
+	if fn := i.config.LineTransform; fn != nil {
+		var ok bool
+		if line, ok = fn(line); !ok {
+			i.droppedLines++
+			return "", false
+		}
+	}
+	return line, true
+}
 
-	// Process the DML
-	i.processDML(scanner)
+// matchMeasurement returns whether the measurement of line is matched by
+// Config.IncludeMeasurements and not by Config.ExcludeMeasurements.
+func (i *Importer) matchMeasurement(line string) bool {
+	key, _, _ := splitLine(line)
+	name := measurementName(key)
+	if len(i.config.IncludeMeasurements) > 0 && !matchAny(i.config.IncludeMeasurements, name) {
+		return false
+	}
+	return !matchAny(i.config.ExcludeMeasurements, name)
+}
 
-	// Check if we had any errors scanning the file
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("reading standard input: %s", err)
+// matchAny returns whether name matches any of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
 	}
+	return false
+}
 
-	// If there were any failed inserts then return an error so that a non-zero
-	// exit code can be returned.
-	if i.failedInserts > 0 {
-		plural := " was"
-		if i.failedInserts > 1 {
-			plural = "s were"
+// inTimeRange returns whether the timestamp of line is between
+// Config.StartTime and Config.EndTime.
+func (i *Importer) inTimeRange(line, precision string) bool {
+	ts, ok := timestamp(line, precision)
+	if !ok {
+		return i.config.KeepUntimedPoints
+	}
+	if !i.config.StartTime.IsZero() && ts < i.config.StartTime.UnixNano() {
+		return false
+	}
+	if !i.config.EndTime.IsZero() && ts >= i.config.EndTime.UnixNano() {
+		return false
+	}
+	return true
+}
+
+// precisionOf returns the precision of the timestamp of line.
+func (i *Importer) precisionOf(line string) string {
+	if len(i.config.MeasurementPrecision) > 0 {
+		key, _, _ := splitLine(line)
+		if p, ok := i.config.MeasurementPrecision[measurementName(key)]; ok {
+			return p
 		}
+	}
+	return i.config.Precision
+}
 
-		return fmt.Errorf("%d point%s not inserted", i.failedInserts, plural)
+// batchFor returns the batch for lines of the given destination and
+// precision, creating it if needed.
+func (i *Importer) batchFor(database, retentionPolicy, precision string) *batch {
+	for _, b := range i.batches {
+		if b.database == database && b.retentionPolicy == retentionPolicy && b.precision == precision {
+			return b
+		}
+	}
+	org, bucket := i.org, i.bucket
+	if fn := i.config.BucketFunc; fn != nil && (database != i.database || retentionPolicy != i.retentionPolicy) {
+		org, bucket = fn(database, retentionPolicy)
 	}
+	b := &batch{
+		database:        database,
+		retentionPolicy: retentionPolicy,
+		org:             org,
+		bucket:          bucket,
+		precision:       precision,
+		lines:           make([]string, 0, i.batchSize()),
+		lineNums:        make([]int, 0, i.batchSize()),
+	}
+	i.batches = append(i.batches, b)
+	return b
+}
 
-	return nil
+// sample returns true if line belongs to a series picked by Config.SampleRatio.
+func (i *Importer) sample(line string) bool {
+	key, _, _ := splitLine(line)
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	keep := float64(sum)/math.MaxUint64 < i.config.SampleRatio
+	i.sampledSeries[sum] = keep
+	return keep
 }
 
-func (i *Importer) processDDL(scanner *bufio.Scanner) {
-	for scanner.Scan() {
-		line := scanner.Text()
-		// If we find the DML token, we are done with DDL
-		if strings.HasPrefix(line, "# DML") {
+// checkSchema returns false if line has a field that Config.Schema does not
+// expect for its measurement, recording the violation.
+func (i *Importer) checkSchema(line string) bool {
+	key, fields, _ := splitLine(line)
+	name := measurementName(key)
+	expected, ok := i.schema[name]
+	if !ok {
+		return true
+	}
+	for _, pair := range splitFields(fields) {
+		if _, ok := expected[fieldKey(pair)]; !ok {
+			i.logf("line %d: field %q not in schema for measurement %q\n", i.lineNum, fieldKey(pair), name)
+			i.schemaViolations[name]++
+			return false
+		}
+	}
+	return true
+}
+
+// progressEvery returns the number of points batched between progress reports.
+// A value <= 0 means progress reporting is disabled.
+func (i *Importer) progressEvery() int {
+	if i.config.ProgressEveryLines == 0 {
+		return defaultProgressEveryLines
+	}
+	return i.config.ProgressEveryLines
+}
+
+// batchSize returns the number of points per write request.
+func (i *Importer) batchSize() int {
+	if i.config.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return i.config.BatchSize
+}
+
+// flush writes and discards all pending batches.
+func (i *Importer) flush() {
+	if len(i.reversed) > 0 {
+		i.flushReversed()
+	}
+	i.flushBatches()
+}
+
+// flushBatches writes the batches of every context.
+func (i *Importer) flushBatches() {
+	for _, b := range i.batches {
+		i.batchWrite(b)
+	}
+	i.batches = i.batches[:0]
+	i.pending.Wait()
+}
+
+// flushIdle writes the lines batched so far for Config.FlushInterval. The
+// lines buffered by Config.ReverseTime are left alone, as only the end of
+// their context or sort window tells where they go.
+func (i *Importer) flushIdle() {
+	i.flushBatches()
+	i.lastWrite = time.Now()
+}
+
+// flushReversed batches the buffered lines of the current context newest
+// first, writing every batch that fills up.
+func (i *Importer) flushReversed() {
+	sort.SliceStable(i.reversed, func(x, y int) bool {
+		return i.reversed[x].ts > i.reversed[y].ts
+	})
+	for _, l := range i.reversed {
+		i.add(i.batchFor(l.database, l.retentionPolicy, l.precision), l.line, l.lineNum)
+	}
+	i.reversed = i.reversed[:0]
+}
+
+func (i *Importer) batchWrite(b *batch) {
+	if i.config.DuplicateStrategy != "" {
+		n := len(b.lines)
+		b.lines, b.lineNums = resolveDuplicates(i.config.DuplicateStrategy, b.lines, b.lineNums)
+		i.duplicatePoints += n - len(b.lines)
+	}
+
+	// Never send an empty write request, or any after too many failures
+	if len(b.lines) == 0 || i.tooManyFailures() != nil {
+		return
+	}
+	i.lastWrite = time.Now()
+
+	// Skip batches that a previous run has already written
+	if i.checkpoint != nil {
+		b.hash = batchHash(b.database, b.retentionPolicy, b.lines)
+		if i.checkpoint.has(b.hash) {
+			i.resumedInserts += len(b.lines)
 			return
 		}
-		if strings.HasPrefix(line, "#") {
-			continue
+	}
+
+	i.batchID++
+	b.id = i.batchID
+
+	// Back off while the server is busy, unless the import is canceled. The
+	// batch is then discarded, or written at once if it is being flushed.
+	if i.config.LoadQuery != "" && i.client != nil {
+		if !i.waitForLoad() && !i.config.FlushOnCancel {
+			return
 		}
-		// Skip blank lines
-		if strings.TrimSpace(line) == "" {
-			continue
+	}
+
+	// Wait until the points per second limit allows the batch, unless the
+	// import is canceled. The batch is then discarded, or written at once if
+	// it is being flushed.
+	throttleStart := time.Now()
+	i.mu.Lock()
+	var wait time.Duration
+	if i.limiter != nil {
+		wait = i.limiter.reserve(len(b.lines), throttleStart)
+	}
+	i.mu.Unlock()
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-i.done:
+			timer.Stop()
+			if !i.config.FlushOnCancel {
+				return
+			}
 		}
-		i.queryExecutor(line)
+		waited := time.Since(throttleStart)
+		i.throttleWait += waited
+		if fn := i.config.OnThrottle; fn != nil {
+			fn(waited)
+		}
+	}
+
+	i.mu.Lock()
+	if b.full {
+		i.fullBatches++
+	} else {
+		i.partialBatches++
+	}
+	i.mu.Unlock()
+
+	if i.jobs != nil {
+		// Hand a copy to the workers, as b is reused once this returns
+		job := *b
+		job.lines = append([]string(nil), b.lines...)
+		job.lineNums = append([]int(nil), b.lineNums...)
+		i.pending.Add(1)
+		i.jobs <- &job
+	} else {
+		i.sendBatch(b)
+	}
+}
+
+// worker sends the batches handed to it by batchWrite until jobs is closed.
+// It is given jobs as close clears i.jobs, possibly before the worker runs.
+func (i *Importer) worker(jobs <-chan *batch) {
+	for b := range jobs {
+		i.sendBatch(b)
+		i.pending.Done()
 	}
 }
 
-func (i *Importer) processDML(scanner *bufio.Scanner) {
+// sendBatch writes b and records the outcome. It may be called from several
+// workers at once.
+func (i *Importer) sendBatch(b *batch) {
 	start := time.Now()
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "# CONTEXT-DATABASE:") {
-			i.database = strings.TrimSpace(strings.Split(line, ":")[1])
+	consistency := i.config.WriteConsistency
+	e := i.writeBatch(b, consistency)
+
+	// Honor the server asking us to slow down and retry the same batch,
+	// unless the import is canceled while waiting
+retry:
+	for n := 0; n < i.maxRateLimitRetries(); n++ {
+		d, ok := retryAfter(e)
+		if !ok {
+			break
 		}
-		if strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:") {
-			i.retentionPolicy = strings.TrimSpace(strings.Split(line, ":")[1])
+		i.logf("rate limited by server, retrying batch in %s\n", d)
+		i.mu.Lock()
+		i.rateLimitWaits++
+		i.mu.Unlock()
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-i.done:
+			timer.Stop()
+			break retry
 		}
-		if strings.HasPrefix(line, "#") {
-			continue
+		e = i.writeBatch(b, consistency)
+	}
+
+	// Settle for a single node when the cluster could not reach the level asked for
+	if i.config.DowngradeConsistencyOnFailure && (consistency == "quorum" || consistency == "all") && isPartialWrite(e) {
+		i.logf("partial write at consistency %s, retrying batch %d at consistency one: %s\n", consistency, b.id, e)
+		consistency = "one"
+		if e = i.writeBatch(b, consistency); e == nil {
+			i.mu.Lock()
+			i.downgraded += len(b.lines)
+			i.mu.Unlock()
 		}
-		// Skip blank lines
-		if strings.TrimSpace(line) == "" {
-			continue
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.results != nil {
+		i.writeResult(b, time.Since(start), e)
+	}
+
+	if e != nil {
+		i.logErrorf("error writing batch: %s\n", e)
+		if i.deadLetters != nil {
+			if err := i.deadLetters.write(b.id, b.database, b.retentionPolicy, b.lines, b.lineNums, e); err != nil {
+				i.logErrorf("error writing dead letters: %s\n", err)
+			}
+		}
+		if i.failedLines != nil {
+			if err := i.failedLines.write(b.lines); err != nil {
+				i.logErrorf("error writing failed lines: %s\n", err)
+			}
+		}
+		if i.deadLetters == nil && i.failedLines == nil {
+			// Output failed lines to STDOUT so users can capture lines that failed to import.
+			// Holding mu, each batch is printed whole even with several workers.
+			fmt.Print(annotateLines(b.lines, b.lineNums))
+		}
+		i.failedInserts += len(b.lines)
+		if isTransient(e) {
+			i.failedTransient += len(b.lines)
+		}
+		if max := i.config.MaxFailures; max > 0 && i.failedInserts > max && i.failuresErr == nil {
+			i.failuresErr = fmt.Errorf("import aborted after %d failed inserts, more than the maximum of %d", i.failedInserts, max)
+		}
+	} else {
+		i.totalInserts += len(b.lines)
+		if !i.throughputStart.IsZero() {
+			sec := int(time.Since(i.throughputStart) / time.Second)
+			for len(i.pointsBySecond) <= sec {
+				i.pointsBySecond = append(i.pointsBySecond, 0)
+			}
+			i.pointsBySecond[sec] += len(b.lines)
+		}
+		if i.measurementPoints != nil {
+			for _, line := range b.lines {
+				key, _, _ := splitLine(line)
+				i.measurementPoints[measurementName(key)]++
+			}
+		}
+		if i.checkpoint != nil {
+			if err := i.checkpoint.add(b.hash); err != nil {
+				i.logErrorf("error recording checkpoint: %s\n", err)
+			}
 		}
-		i.batchAccumulator(line, start)
 	}
-	// Call batchWrite one last time to flush anything out in the batch
-	i.batchWrite()
 }
 
-func (i *Importer) execute(command string) {
-	response, err := i.client.Query(client.Query{Command: command, Database: i.database})
-	if err != nil {
-		log.Printf("error: %s\n", err)
+// logf logs to Config.Logger, or the standard logger if it is nil.
+func (i *Importer) logf(format string, v ...interface{}) {
+	if i.config.Logger != nil {
+		i.config.Logger.Printf(format, v...)
 		return
 	}
-	if err := response.Error(); err != nil {
-		log.Printf("error: %s\n", response.Error())
-	}
+	log.Printf(format, v...)
 }
 
-func (i *Importer) queryExecutor(command string) {
-	i.totalCommands++
-	i.execute(command)
+// logln is like logf but formats like log.Println.
+func (i *Importer) logln(v ...interface{}) {
+	if i.config.Logger != nil {
+		i.config.Logger.Println(v...)
+		return
+	}
+	log.Println(v...)
 }
 
-func (i *Importer) batchAccumulator(line string, start time.Time) {
-	i.batch = append(i.batch, line)
-	if len(i.batch) == batchSize {
-		i.batchWrite()
-		i.batch = i.batch[:0]
-		// Give some status feedback every 100000 lines processed
-		processed := i.totalInserts + i.failedInserts
-		if processed%100000 == 0 {
-			since := time.Since(start)
-			pps := float64(processed) / since.Seconds()
-			log.Printf("Processed %d lines.  Time elapsed: %s.  Points per second (PPS): %d", processed, since.String(), int64(pps))
+// logErrorf logs an error unless Config.MaxErrorsPerSecond errors have
+// already been logged in the current second.
+func (i *Importer) logErrorf(format string, v ...interface{}) {
+	if i.config.MaxErrorsPerSecond > 0 {
+		i.logMu.Lock()
+		defer i.logMu.Unlock()
+		if now := time.Now(); now.Sub(i.errorWindow) >= time.Second {
+			if i.errorsSuppressed > 0 {
+				i.logf("suppressed %d errors\n", i.errorsSuppressed)
+			}
+			i.errorWindow = now
+			i.errorsLogged = 0
+			i.errorsSuppressed = 0
+		}
+		if i.errorsLogged >= i.config.MaxErrorsPerSecond {
+			i.errorsSuppressed++
+			i.totalSuppressed++
+			return
 		}
+		i.errorsLogged++
 	}
+	i.logf(format, v...)
 }
 
-func (i *Importer) batchWrite() {
-	// Accumulate the batch size to see how many points we have written this second
-	i.throttlePointsWritten += len(i.batch)
+// maxRateLimitRetries returns the number of retries of a rate limited write.
+func (i *Importer) maxRateLimitRetries() int {
+	switch {
+	case i.config.MaxRateLimitRetries < 0:
+		return 0
+	case i.config.MaxRateLimitRetries == 0:
+		return defaultMaxRateLimitRetries
+	}
+	return i.config.MaxRateLimitRetries
+}
 
-	// Find out when we last wrote data
-	since := time.Since(i.lastWrite)
+// retryAfter returns how long to wait before retrying a write that failed
+// with err because the server is rate limiting requests.
+func retryAfter(err error) (time.Duration, bool) {
+	e, ok := err.(*client.WriteError)
+	if !ok || e.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
 
-	// Check to see if we've exceeded our points per second for the current timeframe
-	var currentPPS int
-	if since.Seconds() > 0 {
-		currentPPS = int(float64(i.throttlePointsWritten) / since.Seconds())
-	} else {
-		currentPPS = i.throttlePointsWritten
+	// Retry-After holds either a number of seconds or an HTTP date.
+	v := e.Header.Get("Retry-After")
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(time.Now()); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return defaultRetryAfter, true
+}
+
+// bucketPrecision converts a write precision to the form the /api/v2/write
+// endpoint accepts.
+func bucketPrecision(precision string) string {
+	switch precision {
+	case "", "n":
+		return "ns"
+	case "u":
+		return "us"
 	}
+	return precision
+}
 
-	// If our currentPPS is greater than the PPS specified, then we wait and retry
-	if int(currentPPS) > i.config.PPS && i.config.PPS != 0 {
-		// Wait for the next tick
-		<-i.throttle.C
+// isPartialWrite returns whether err is a write that reached fewer nodes
+// than its write consistency asked for. Partial writes the server rejects
+// as bad requests, such as field type conflicts or points beyond the
+// retention policy, fail the same way at any consistency.
+func isPartialWrite(err error) bool {
+	e, ok := err.(*client.WriteError)
+	return ok && e.StatusCode >= 500 && (strings.Contains(e.Body, "partial write") || strings.Contains(e.Body, "hinted handoff"))
+}
 
-		// Decrement the batch size back out as it is going to get called again
-		i.throttlePointsWritten -= len(i.batch)
-		i.batchWrite()
-		return
+// isTransient returns true if a write that failed with err may succeed when
+// retried later: the server was unreachable, overloaded or failed
+// internally. Writes the server rejected as bad requests, or that were
+// rejected before being sent, fail permanently.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *client.WriteError:
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	case net.Error:
+		return true
 	}
+	return false
+}
 
-	_, e := i.client.WriteLineProtocol(strings.Join(i.batch, "\n"), i.database, i.retentionPolicy, i.config.Precision, i.config.WriteConsistency)
-	if e != nil {
-		log.Println("error writing batch: ", e)
-		// Output failed lines to STDOUT so users can capture lines that failed to import
-		fmt.Println(strings.Join(i.batch, "\n"))
-		i.failedInserts += len(i.batch)
+// writeBatch sends b to the server, at the given write consistency, or to
+// the sink, giving Config.BeforeWrite the chance to veto it first.
+func (i *Importer) writeBatch(b *batch, consistency string) error {
+	i.mu.Lock()
+	i.writeRequests++
+	i.mu.Unlock()
+	if fn := i.config.BeforeWrite; fn != nil {
+		if err := fn(b.database, b.retentionPolicy, b.lines); err != nil {
+			return err
+		}
+	}
+	if i.config.Sink != nil {
+		return i.config.Sink.WriteBatch(b.database, b.retentionPolicy, b.lines)
+	}
+
+	var resp *client.Response
+	var err error
+	if i.config.BucketFunc != nil {
+		var r io.Reader = strings.NewReader(strings.Join(b.lines, "\n"))
+		if i.config.StreamWrites {
+			r = newLinesReader(b.lines)
+		}
+		resp, err = i.client.WriteBucketLineProtocolReader(r, b.org, b.bucket, bucketPrecision(b.precision))
+	} else if i.config.StreamWrites {
+		resp, err = i.client.WriteLineProtocolReader(newLinesReader(b.lines), b.database, b.retentionPolicy, b.precision, consistency)
 	} else {
-		i.totalInserts += len(i.batch)
+		resp, err = i.client.WriteLineProtocol(strings.Join(b.lines, "\n"), b.database, b.retentionPolicy, b.precision, consistency)
 	}
-	i.throttlePointsWritten = 0
-	i.lastWrite = time.Now()
-	return
+	if fn := i.config.SuccessFunc; fn != nil {
+		if fn(resp, err) {
+			return nil
+		}
+		if err == nil {
+			return errWriteRejected
+		}
+	}
+	return err
 }
@@ -0,0 +1,3971 @@
+package v8_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/importer/v8"
+)
+
+func TestImporter_TimezoneOffset(t *testing.T) {
+	tests := []struct {
+		precision string
+		ts        string
+		exp       string
+	}{
+		{precision: "ns", ts: "1464026335000000000", exp: "1464044335000000000"},
+		{precision: "u", ts: "1464026335000000", exp: "1464044335000000"},
+		{precision: "ms", ts: "1464026335000", exp: "1464044335000"},
+		{precision: "s", ts: "1464026335", exp: "1464044335"},
+		{precision: "m", ts: "24400438", exp: "24400738"},
+		{precision: "h", ts: "406673", exp: "406678"},
+	}
+
+	for _, tt := range tests {
+		s := NewServer()
+		path := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server\ 1 value=33.3,desc="a b" `+tt.ts+`
+cpu,host=server1 value=43.3
+`)
+
+		config := s.Config(path)
+		config.Precision = tt.precision
+		config.TimezoneOffset = 5 * time.Hour
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.precision, err)
+		}
+		s.Close()
+		os.Remove(path)
+
+		if len(s.Writes) != 1 {
+			t.Fatalf("%s: unexpected write count: %d", tt.precision, len(s.Writes))
+		}
+		exp := `cpu,host=server\ 1 value=33.3,desc="a b" ` + tt.exp + "\ncpu,host=server1 value=43.3"
+		if got := s.Writes[0].Body; got != exp {
+			t.Errorf("%s: unexpected body:\n\nexp=%s\n\ngot=%s", tt.precision, exp, got)
+		}
+		if got := s.Writes[0].Precision; got != tt.precision {
+			t.Errorf("%s: unexpected precision: %s", tt.precision, got)
+		}
+	}
+}
+
+func TestImporter_TimeRange(t *testing.T) {
+	path := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1 1464026335
+cpu value=2 1464026345
+cpu value=3 1464026355
+cpu value=4
+`)
+	defer os.Remove(path)
+
+	tests := []struct {
+		start, end int64
+		keep       bool
+		exp        string
+	}{
+		{start: 1464026345, exp: "cpu value=2 1464026345\ncpu value=3 1464026355"},
+		{end: 1464026355, exp: "cpu value=1 1464026335\ncpu value=2 1464026345"},
+		{start: 1464026340, end: 1464026350, keep: true, exp: "cpu value=2 1464026345\ncpu value=4"},
+	}
+	for _, tt := range tests {
+		s := NewServer()
+		config := s.Config(path)
+		config.Precision = "s"
+		if tt.start != 0 {
+			config.StartTime = time.Unix(tt.start, 0)
+		}
+		if tt.end != 0 {
+			config.EndTime = time.Unix(tt.end, 0)
+		}
+		config.KeepUntimedPoints = tt.keep
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		if len(s.Writes) != 1 || s.Writes[0].Body != tt.exp {
+			t.Errorf("[%d, %d): unexpected writes: %v", tt.start, tt.end, s.Writes)
+		}
+	}
+}
+
+func TestImporter_FilterMeasurements(t *testing.T) {
+	path := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1
+cpu_load value=2
+mem value=3
+disk value=4
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.IncludeMeasurements = []string{"cpu*", "mem"}
+	config.ExcludeMeasurements = []string{"*_load"}
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Body != "cpu value=1\nmem value=3" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+	if stats := i.Stats(); stats.Inserts != 2 || stats.Failed != 0 || stats.Filtered != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	config.IncludeMeasurements = []string{"cpu["}
+	if _, err := v8.NewImporter(config).Import(); err == nil || !strings.Contains(err.Error(), "invalid measurement pattern") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestImporter_LineTransform(t *testing.T) {
+	path := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1
+debug value=2
+mem value=3
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.AddTags = map[string]string{"dc": "east"}
+	config.LineTransform = func(line string) (string, bool) {
+		if strings.HasPrefix(line, "debug") {
+			return "", false
+		}
+		return strings.ToUpper(line[:1]) + line[1:], true
+	}
+	result, err := v8.NewImporter(config).Import()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Body != "Cpu,dc=east value=1\nMem,dc=east value=3" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+	if result.TotalInserts != 2 || result.Skipped != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestImporter_CommentAndBlankLines(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+# exported from server1
+cpu value=1
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	result, err := v8.NewImporter(s.Config(path)).Import()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CommentLines != 5 || result.BlankLines != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestImporter_BeforeWrite(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	var calls int
+	config := s.Config(path)
+	config.BeforeWrite = func(db, rp string, lines []string) error {
+		calls++
+		if db != "db0" || rp != "autogen" {
+			t.Errorf("unexpected context: db=%s rp=%s", db, rp)
+		}
+		if len(lines) != 2 {
+			t.Errorf("unexpected line count: %d", len(lines))
+		}
+		return errors.New("vetoed")
+	}
+
+	if _, err := v8.NewImporter(config).Import(); err == nil || err.Error() != "2 points were not inserted" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected BeforeWrite call count: %d", calls)
+	}
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_Checkpoint(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	checkpoint := MustWriteDump(t, "")
+	defer os.Remove(checkpoint)
+
+	// The first run writes the batch and records it.
+	s := NewServer()
+	config := s.Config(path)
+	config.CheckpointPath = checkpoint
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected write count: %d", len(s.Writes))
+	}
+
+	// The second run finds the batch in the checkpoint and skips it.
+	s = NewServer()
+	config = s.Config(path)
+	config.CheckpointPath = checkpoint
+	config.Resume = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+
+	// Without Resume, the checkpoint is ignored and the batch written again.
+	s = NewServer()
+	config = s.Config(path)
+	config.CheckpointPath = checkpoint
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected write count: %d", len(s.Writes))
+	}
+}
+
+func TestImporter_Checkpoint_Resume(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 100; n++ {
+		fmt.Fprintf(&buf, "cpu,host=server%d value=%d 1464026335000000000\n", n, n)
+	}
+	path := MustWriteDump(t, buf.String())
+	defer os.Remove(path)
+
+	checkpoint := MustWriteDump(t, "")
+	defer os.Remove(checkpoint)
+
+	// The first run is killed after its fourth batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer()
+	s.WriteFn = func(w Write) error {
+		if len(s.Writes) == 3 {
+			cancel()
+		}
+		return nil
+	}
+	config := s.Config(path)
+	config.BatchSize = 10
+	config.CheckpointPath = checkpoint
+	if _, err := v8.NewImporter(config).ImportContext(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	s.Close()
+	killed := s.Writes
+	if len(killed) == 0 || len(killed) == 10 {
+		t.Fatalf("unexpected write count of the killed run: %d", len(killed))
+	}
+
+	// The resumed run writes the remaining batches, and every point once.
+	s = NewServer()
+	config = s.Config(path)
+	config.BatchSize = 10
+	config.CheckpointPath = checkpoint
+	config.Resume = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if len(killed)+len(s.Writes) != 10 {
+		t.Fatalf("unexpected write counts: %d then %d", len(killed), len(s.Writes))
+	}
+	seen := make(map[string]bool)
+	for _, w := range append(killed, s.Writes...) {
+		for _, line := range strings.Split(w.Body, "\n") {
+			if seen[line] {
+				t.Fatalf("duplicate point: %s", line)
+			}
+			seen[line] = true
+		}
+	}
+	if len(seen) != 100 {
+		t.Fatalf("unexpected point count: %d", len(seen))
+	}
+}
+
+func TestImporter_Checkpoint_Concurrency(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 200; n++ {
+		fmt.Fprintf(&buf, "cpu,host=server%d value=%d 1464026335000000000\n", n, n)
+	}
+	path := MustWriteDump(t, buf.String())
+	defer os.Remove(path)
+
+	checkpoint := MustWriteDump(t, "")
+	defer os.Remove(checkpoint)
+
+	// Workers record the batches written while the next ones are looked up.
+	for _, exp := range []int{20, 0} {
+		s := NewServer()
+		s.WriteFn = func(w Write) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		config := s.Config(path)
+		config.BatchSize = 10
+		config.Concurrency = 4
+		config.CheckpointPath = checkpoint
+		config.Resume = true
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		if len(s.Writes) != exp {
+			t.Fatalf("expected %d writes, got %d", exp, len(s.Writes))
+		}
+	}
+}
+
+func TestImporter_DeadLetterPath(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error { return errors.New("bad point") }
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+
+	config := s.Config(path)
+	config.DeadLetterPath = deadLetters
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	f, err := os.Open(deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []v8.DeadLetter
+	dec := json.NewDecoder(f)
+	for {
+		var dl v8.DeadLetter
+		if err := dec.Decode(&dl); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, dl)
+	}
+
+	exp := []v8.DeadLetter{
+		{Line: 7, Batch: 1, Database: "db0", RetentionPolicy: "autogen", Text: "cpu,host=server1 value=33.3 1464026335000000000", Error: `{"error":"bad point"}` + "\n"},
+		{Line: 9, Batch: 1, Database: "db0", RetentionPolicy: "autogen", Text: "cpu,host=server1 value=43.3 1464026395000000000", Error: `{"error":"bad point"}` + "\n"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected dead letters:\n\nexp=%+v\n\ngot=%+v", exp, got)
+	}
+}
+
+func TestImporter_ImportDeadLetters(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+# CONTEXT-DATABASE:db2
+cpu,host=server1 value=3 1464026395000000000
+`)
+	defer os.Remove(path)
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+	retryDeadLetters := MustWriteDump(t, "")
+	defer os.Remove(retryDeadLetters)
+
+	// The first run fails to write db1 and db2.
+	s := NewServer()
+	s.WriteFn = func(w Write) error {
+		if w.Database != "db0" {
+			return errors.New("database not found")
+		}
+		return nil
+	}
+	config := s.Config(path)
+	config.DeadLetterPath = deadLetters
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+	s.Close()
+
+	// The retry writes the failed lines to their databases, and db2 fails again.
+	s = NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error {
+		if strings.Contains(w.Body, "value=3") {
+			return errors.New("bad point")
+		}
+		return nil
+	}
+	config = s.Config("")
+	config.DeadLetterPath = deadLetters
+	if _, err := v8.NewImporter(config).ImportDeadLetters(context.Background(), deadLetters); err == nil {
+		t.Fatal("expected error")
+	}
+	config.DeadLetterPath = retryDeadLetters
+	stats, err := v8.NewImporter(config).ImportDeadLetters(context.Background(), deadLetters)
+	if err == nil || err.Error() != "1 point was not inserted" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Inserts != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Database != "db1" || s.Writes[0].RetentionPolicy != "autogen" {
+		t.Fatalf("unexpected writes: %+v", s.Writes)
+	}
+
+	b, err := ioutil.ReadFile(retryDeadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dl v8.DeadLetter
+	if err := json.Unmarshal(b, &dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Line != 11 || dl.Database != "db2" || dl.Text != "cpu,host=server1 value=3 1464026395000000000" {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+}
+
+func TestImporter_FailedLinesPath(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error { return errors.New("bad point") }
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	// Failed lines are appended to what is already in the file.
+	failedLines := MustWriteDump(t, "cpu,host=server0 value=1 1464026335000000000\n")
+	defer os.Remove(failedLines)
+
+	config := s.Config(path)
+	config.FailedLinesPath = failedLines
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	b, err := ioutil.ReadFile(failedLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "cpu,host=server0 value=1 1464026335000000000\ncpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=43.3 1464026395000000000\n"
+	if string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%q\n\ngot=%q", exp, b)
+	}
+}
+
+func TestImporter_FailedLinesPath_Gzip(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error { return errors.New("bad point") }
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	dir, err := ioutil.TempDir("", "influxdb-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A second import appends another gzip member.
+	config := s.Config(path)
+	config.FailedLinesPath = filepath.Join(dir, "failed.gz")
+	for n := 0; n < 2; n++ {
+		if _, err := v8.NewImporter(config).Import(); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+
+	f, err := os.Open(config.FailedLinesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "cpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=33.3 1464026335000000000\n"
+	if string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%q\n\ngot=%q", exp, b)
+	}
+}
+
+func TestImporter_EmptyBatch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+`)
+	defer os.Remove(path)
+
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_SuccessFunc(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	var calls int
+	config := s.Config(path)
+	config.SuccessFunc = func(resp *client.Response, err error) bool {
+		calls++
+		return false
+	}
+	if _, err := v8.NewImporter(config).Import(); err == nil || err.Error() != "1 point was not inserted" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected SuccessFunc call count: %d", calls)
+	}
+}
+
+func TestImporter_Schema(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3,idle=1 1464026335000000000
+cpu,host=server1 value=43.3,user=2 1464026395000000000
+mem,host=server1 free=1 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	for _, warnOnly := range []bool{false, true} {
+		s := NewServer()
+		config := s.Config(path)
+		config.Schema = map[string][]string{"cpu": {"value", "idle"}}
+		config.SchemaWarnOnly = warnOnly
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		exp := "cpu,host=server1 value=33.3,idle=1 1464026335000000000\nmem,host=server1 free=1 1464026395000000000"
+		if warnOnly {
+			exp = "cpu,host=server1 value=33.3,idle=1 1464026335000000000\ncpu,host=server1 value=43.3,user=2 1464026395000000000\nmem,host=server1 free=1 1464026395000000000"
+		}
+		if len(s.Writes) != 1 || s.Writes[0].Body != exp {
+			t.Fatalf("warnOnly=%v: unexpected writes: %v", warnOnly, s.Writes)
+		}
+	}
+}
+
+func TestImporter_StreamWrites(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	config := s.Config(path)
+	config.StreamWrites = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "cpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=43.3 1464026395000000000"
+	if len(s.Writes) != 1 || s.Writes[0].Body != exp {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func BenchmarkImporter_Import(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 50000; n++ {
+		fmt.Fprintf(&buf, "cpu,host=server%d,region=us-west value=%d,idle=%d.5 %d\n", n%100, n, n, 1464026335000000000+n)
+	}
+
+	f, err := ioutil.TempFile("", "influxdb-importer-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		b.Fatal(err)
+	}
+	f.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/query" {
+			w.Write([]byte(`{"results":[{}]}`))
+			return
+		}
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	for _, tt := range []struct{ stream, chunked bool }{
+		{false, false},
+		{true, false},
+		{true, true},
+	} {
+		b.Run(fmt.Sprintf("StreamWrites=%v/ChunkedWrites=%v", tt.stream, tt.chunked), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				config := v8.NewConfig()
+				config.URL = *u
+				config.Path = f.Name()
+				config.ProgressEveryLines = v8.NoProgress
+				config.StreamWrites = tt.stream
+				config.ChunkedWrites = tt.chunked
+				if _, err := v8.NewImporter(config).Import(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestImporter_OnContextChange(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value=43.3 1464026395000000000
+
+# CONTEXT-DATABASE:db1
+# CONTEXT-RETENTION-POLICY:rp1
+cpu,host=server1 value=73.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	var contexts []string
+	config := s.Config(path)
+	config.OnContextChange = func(db, rp string) {
+		// Lines of the previous context must have been written already.
+		contexts = append(contexts, fmt.Sprintf("%s.%s:%d", db, rp, len(s.Writes)))
+	}
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []string{"db0.autogen:0", "db1.rp1:1"}; !reflect.DeepEqual(contexts, exp) {
+		t.Fatalf("unexpected contexts: %v", contexts)
+	}
+	exp := []Write{
+		{Database: "db0", RetentionPolicy: "autogen", Body: "cpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=43.3 1464026395000000000"},
+		{Database: "db1", RetentionPolicy: "rp1", Body: "cpu,host=server1 value=73.3 1464026335000000000"},
+	}
+	if !reflect.DeepEqual(s.Writes, exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%+v\n\ngot=%+v", exp, s.Writes)
+	}
+}
+
+func TestImporter_LineRanges(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1 1
+cpu value=2 2
+cpu value=3 3
+cpu value=4 4
+cpu value=5 5
+`)
+	defer os.Remove(path)
+
+	config := s.Config(path)
+	config.LineRanges = []v8.LineRange{{Start: 1, End: 7}, {Start: 9, End: 10}}
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Queries) != 1 {
+		t.Fatalf("unexpected queries: %v", s.Queries)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Body != "cpu value=1 1\ncpu value=3 3\ncpu value=4 4" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_DumpVersion(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	for _, tt := range []struct {
+		header string
+		exp    string
+	}{
+		{header: "# Exported from InfluxDB version 0.8.9", exp: "0.8.9"},
+		{header: "# VERSION: v1.2.0", exp: "1.2.0"},
+		{header: "# Found 999 Series for export", exp: ""},
+	} {
+		path := MustWriteDump(t, `
+# DDL
+`+tt.header+`
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1 1
+`)
+		i := v8.NewImporter(s.Config(path))
+		if _, err := i.Import(); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(path)
+
+		if got := i.DumpVersion(); got != tt.exp {
+			t.Errorf("%s: unexpected version: %q", tt.header, got)
+		}
+	}
+}
+
+func TestImporter_DatabaseFileSink(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE DATABASE db1
+CREATE RETENTION POLICY rp1 ON db1 DURATION 1h REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+
+# CONTEXT-DATABASE:db1
+# CONTEXT-RETENTION-POLICY:rp1
+cpu,host=server1 value=73.3 1464026335000000000
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=83.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	dir, err := ioutil.TempDir("", "influxdb-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// No server is needed when writing to a sink.
+	sink := v8.NewDatabaseFileSink(dir)
+	config := v8.NewConfig()
+	config.Path = path
+	config.Sink = sink
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for db, exp := range map[string]string{
+		"db0": `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`,
+		"db1": `# DDL
+CREATE DATABASE db1
+CREATE RETENTION POLICY rp1 ON db1 DURATION 1h REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:db1
+# CONTEXT-RETENTION-POLICY:rp1
+cpu,host=server1 value=73.3 1464026335000000000
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=83.3 1464026395000000000
+`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, db))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != exp {
+			t.Errorf("%s: unexpected dump:\n\nexp=%s\n\ngot=%s", db, exp, b)
+		}
+	}
+}
+
+func TestImporter_SpecialDatabaseNames(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE "my-db"
+CREATE RETENTION POLICY "one week" ON "my-db" DURATION 1w REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:my-db
+# CONTEXT-RETENTION-POLICY:one week
+cpu,host=server1 value=33.3 1464026335000000000
+# CONTEXT-DATABASE:my db
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	// Names are passed to the write API as they are.
+	s := NewServer()
+	defer s.Close()
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 2 ||
+		s.Writes[0].Database != "my-db" || s.Writes[0].RetentionPolicy != "one week" ||
+		s.Writes[1].Database != "my db" || s.Writes[1].RetentionPolicy != "autogen" {
+		t.Fatalf("unexpected writes: %+v", s.Writes)
+	}
+
+	// Names are quoted in the DDL the file sink generates.
+	dir, err := ioutil.TempDir("", "influxdb-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := v8.NewDatabaseFileSink(dir)
+	config := v8.NewConfig()
+	config.Path = path
+	config.Sink = sink
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exp := range map[string]string{
+		"my-db": `# DDL
+CREATE DATABASE "my-db"
+CREATE RETENTION POLICY "one week" ON "my-db" DURATION 1w REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:my-db
+# CONTEXT-RETENTION-POLICY:one week
+cpu,host=server1 value=33.3 1464026335000000000
+`,
+		"my%20db": `# DDL
+CREATE DATABASE "my db"
+
+# DML
+# CONTEXT-DATABASE:my db
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=43.3 1464026395000000000
+`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != exp {
+			t.Errorf("%s: unexpected dump:\n\nexp=%s\n\ngot=%s", name, exp, b)
+		}
+	}
+}
+
+func TestImporter_RateLimited(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var attempts int
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if attempts++; attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return true
+		}
+		return false
+	}
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || len(s.Writes) != 1 {
+		t.Fatalf("unexpected attempts=%d writes=%v", attempts, s.Writes)
+	}
+}
+
+func TestImporter_RateLimited_Canceled(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+		return true
+	}
+
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	config := s.Config(path)
+	config.DeadLetterPath = path + ".dead" // keep failed lines off stdout
+	defer os.Remove(config.DeadLetterPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	i := v8.NewImporter(config)
+	if _, err := i.ImportContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("import took %s after being canceled", d)
+	}
+	if stats := i.Stats(); stats.Failed != 1 || stats.FailedTransient != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Retries are limited.
+	config.MaxRateLimitRetries = -1
+	i = v8.NewImporter(config)
+	if _, err := i.Import(); err == nil {
+		t.Fatal("expected error")
+	}
+	if stats := i.Stats(); stats.WriteRequests != 1 || stats.FailedTransient != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_SampleRatio(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 1000; n++ {
+		for ts := 0; ts < 3; ts++ {
+			fmt.Fprintf(&buf, "cpu,host=server%d value=%d %d\n", n, ts, ts)
+		}
+	}
+	path := MustWriteDump(t, buf.String())
+	defer os.Remove(path)
+
+	var runs [2]map[string]int
+	for n := range runs {
+		s := NewServer()
+		config := s.Config(path)
+		config.SampleRatio = 0.25
+		result, err := v8.NewImporter(config).Import()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		if result.TotalInserts+result.Skipped != 3000 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+
+		runs[n] = make(map[string]int)
+		for _, w := range s.Writes {
+			for _, line := range strings.Split(w.Body, "\n") {
+				runs[n][strings.Fields(line)[0]]++
+			}
+		}
+	}
+
+	// Roughly a quarter of the series are kept in full on every run.
+	if len(runs[0]) < 200 || len(runs[0]) > 300 {
+		t.Fatalf("unexpected series count: %d", len(runs[0]))
+	}
+	for key, count := range runs[0] {
+		if count != 3 {
+			t.Fatalf("series %s not kept in full: %d points", key, count)
+		}
+	}
+	if !reflect.DeepEqual(runs[0], runs[1]) {
+		t.Fatal("sampled series differ between runs")
+	}
+}
+
+func TestImporter_ValidateLines(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value= 1464026395000000000
+cpu,host=server1 value=43.3 1464026455000000000
+`)
+	defer os.Remove(path)
+
+	// Invalid lines are skipped.
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.ValidateLines = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=43.3 1464026455000000000" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+
+	// Invalid lines are dead-lettered.
+	deadLetters := path + ".dead"
+	defer os.Remove(deadLetters)
+	s = NewServer()
+	defer s.Close()
+	config = s.Config(path)
+	config.ValidateLines = true
+	config.DeadLetterPath = deadLetters
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dl v8.DeadLetter
+	if err := json.Unmarshal(buf, &dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Line != 8 || dl.Batch != 0 || dl.Database != "db0" || dl.Text != "cpu,host=server1 value= 1464026395000000000" || dl.Error == "" {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+
+	// The import stops at the first invalid line.
+	s = NewServer()
+	defer s.Close()
+	config = s.Config(path)
+	config.ValidateLines = true
+	config.StopOnFirstInvalid = true
+	_, err = v8.NewImporter(config).Import()
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid line 8: ") || !strings.HasSuffix(err.Error(), `"cpu,host=server1 value= 1464026395000000000"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_StopOnDDLError(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("q") != "CREATE DATABASE db0" {
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"error":"permission denied"}]}`))
+		return true
+	}
+
+	// By default the error is logged and the import goes on.
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+
+	s.Writes = nil
+	config := s.Config(path)
+	config.StopOnDDLError = true
+	_, err := v8.NewImporter(config).Import()
+	if exp := `error executing "CREATE DATABASE db0": permission denied`; err == nil || err.Error() != exp {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_NoDML(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	var buf bytes.Buffer
+	config := s.Config(path)
+	config.Logger = log.New(&buf, "", 0)
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "warning: dump has no # DML section") {
+		t.Fatalf("expected a warning, got:\n%s", buf.String())
+	}
+
+	config.RequireDML = true
+	if _, err := v8.NewImporter(config).Import(); err != v8.ErrNoDML {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestImporter_MeasurementPrecision(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+mem,host=server1 value=1024 1464026335
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.MeasurementPrecision = map[string]string{"mem": "s"}
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Writes) != 2 {
+		t.Fatalf("unexpected write count: %d", len(s.Writes))
+	}
+	if w := s.Writes[0]; w.Precision != "" || w.Body != "cpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=43.3 1464026395000000000" {
+		t.Errorf("unexpected write: %+v", w)
+	}
+	if w := s.Writes[1]; w.Precision != "s" || w.Body != "mem,host=server1 value=1024 1464026335" {
+		t.Errorf("unexpected write: %+v", w)
+	}
+}
+
+func TestImporter_GenerateAndImport(t *testing.T) {
+	spec := v8.GenSpec{
+		Database:     "db0",
+		Measurements: 2,
+		Series:       3,
+		Fields:       2,
+		Start:        time.Unix(0, 0),
+		End:          time.Unix(4, 0),
+		Interval:     time.Second,
+		Seed:         1,
+	}
+
+	var bodies []string
+	for n := 0; n < 2; n++ {
+		s := NewServer()
+		config := s.Config("")
+		config.Precision = "s"
+		stats, err := v8.NewImporter(config).GenerateAndImport(spec)
+		s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != (v8.Stats{Commands: 1, Inserts: 24, WriteRequests: 1, PartialBatches: 1}) {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+		if len(s.Queries) != 1 || s.Queries[0] != "CREATE DATABASE db0" {
+			t.Fatalf("unexpected queries: %v", s.Queries)
+		}
+		if len(s.Writes) != 1 || s.Writes[0].Database != "db0" {
+			t.Fatalf("unexpected writes: %v", s.Writes)
+		}
+		bodies = append(bodies, s.Writes[0].Body)
+	}
+
+	lines := strings.Split(bodies[0], "\n")
+	if !strings.HasPrefix(lines[0], "m0,tag0=v0 field0=") || !strings.HasSuffix(lines[0], " 0") {
+		t.Errorf("unexpected first line: %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[23], "m1,tag0=v2 field0=") || !strings.HasSuffix(lines[23], " 3") {
+		t.Errorf("unexpected last line: %s", lines[23])
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("generated points differ with the same seed:\n\n%s\n\n%s", bodies[0], bodies[1])
+	}
+
+	// The same options as for a dump apply to generated points.
+	s := NewServer()
+	defer s.Close()
+	config := s.Config("")
+	config.Precision = "s"
+	config.DropFields = []string{"field1"}
+	if _, err := v8.NewImporter(config).GenerateAndImport(spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || strings.Contains(s.Writes[0].Body, "field1=") {
+		t.Fatalf("expected field1 to be dropped: %v", s.Writes)
+	}
+}
+
+func TestImporter_DedupeDDL(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1w REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+# DDL
+CREATE  DATABASE db0
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db1
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=43.3 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.DedupeDDL = true
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []string{
+		"CREATE DATABASE db0",
+		"CREATE RETENTION POLICY rp0 ON db0 DURATION 1w REPLICATION 1",
+		"CREATE DATABASE db1",
+	}; !reflect.DeepEqual(s.Queries, exp) {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	if stats := i.Stats(); stats != (v8.Stats{Commands: 3, Inserts: 2, DedupedDDL: 1, WriteRequests: 2, PartialBatches: 2}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(s.Writes) != 2 || s.Writes[0].Database != "db0" || s.Writes[1].Database != "db1" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_DDLConcurrency(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1
+CREATE DATABASE db1
+CREATE RETENTION POLICY rp0 ON db1 DURATION 1h REPLICATION 1
+CREATE RETENTION POLICY rp1 ON db0 DURATION 2h REPLICATION 1
+CREATE USER u0 WITH PASSWORD 'p'
+GRANT ALL ON db0 TO u0
+CREATE DATABASE db2
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.DDLConcurrency = 4
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	index := make(map[string]int)
+	for n, q := range s.Queries {
+		index[q] = n
+	}
+	if len(index) != 8 {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	for _, before := range [][2]string{
+		{"CREATE DATABASE db0", "CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1"},
+		{"CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1", "CREATE RETENTION POLICY rp1 ON db0 DURATION 2h REPLICATION 1"},
+		{"CREATE DATABASE db1", "CREATE RETENTION POLICY rp0 ON db1 DURATION 1h REPLICATION 1"},
+		{"CREATE RETENTION POLICY rp1 ON db0 DURATION 2h REPLICATION 1", "CREATE USER u0 WITH PASSWORD 'p'"},
+		{"CREATE RETENTION POLICY rp0 ON db1 DURATION 1h REPLICATION 1", "CREATE USER u0 WITH PASSWORD 'p'"},
+		{"CREATE USER u0 WITH PASSWORD 'p'", "GRANT ALL ON db0 TO u0"},
+		{"GRANT ALL ON db0 TO u0", "CREATE DATABASE db2"},
+	} {
+		if index[before[0]] > index[before[1]] {
+			t.Errorf("%q executed after %q", before[0], before[1])
+		}
+	}
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_DDLConcurrency_StopOnDDLError(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY bad ON db0 DURATION 1h REPLICATION 1
+CREATE RETENTION POLICY rp1 ON db0 DURATION 2h REPLICATION 1
+CREATE USER u0 WITH PASSWORD 'p'
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Query().Get("q"), "CREATE RETENTION POLICY bad") {
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"error":"denied"}]}`))
+		return true
+	}
+	config := s.Config(path)
+	config.DDLConcurrency = 4
+	config.StopOnDDLError = true
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(s.Queries) != 2 || s.Queries[0] != "CREATE DATABASE db0" {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_Preview(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026335000000000 invalid
+cpu,host=server1 value=3 1464026335000000000
+cpu,host=server1 value=4 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.ValidateLines = true
+	config.RenameTags = map[string]string{"host": "server"}
+
+	var buf bytes.Buffer
+	i := v8.NewImporter(config)
+	if err := i.Preview(&buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	exp := `line 7:
+  before: cpu,host=server1 value=1 1464026335000000000
+  after:  cpu,server=server1 value=1 1464026335000000000
+line 8:
+  before: cpu,host=server1 value=2 1464026335000000000 invalid
+  after:  (dropped)
+line 9:
+  before: cpu,host=server1 value=3 1464026335000000000
+  after:  cpu,server=server1 value=3 1464026335000000000
+`
+	if got := buf.String(); got != exp {
+		t.Fatalf("unexpected preview:\n\nexp=%s\n\ngot=%s", exp, got)
+	}
+	if len(s.Queries) != 0 || len(s.Writes) != 0 {
+		t.Fatalf("unexpected requests: %v %v", s.Queries, s.Writes)
+	}
+
+	// Previewing again starts over from the first line.
+	buf.Reset()
+	if err := i.Preview(&buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != exp {
+		t.Fatalf("unexpected second preview:\n\nexp=%s\n\ngot=%s", exp, got)
+	}
+
+	// So does a dump split across files.
+	buf.Reset()
+	config.Path, config.SplitPaths = "", []string{path}
+	if err := v8.NewImporter(config).Preview(&buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != exp {
+		t.Fatalf("unexpected preview of split dump:\n\nexp=%s\n\ngot=%s", exp, got)
+	}
+}
+
+func TestImporter_OnThrottle(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.PPS = 100
+	var waits []time.Duration
+	config.OnThrottle = func(waited time.Duration) { waits = append(waits, waited) }
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(waits) != 2 {
+		t.Fatalf("unexpected throttle waits: %v", waits)
+	}
+	for _, d := range waits {
+		if d < 5*time.Millisecond {
+			t.Fatalf("unexpected throttle wait: %s", d)
+		}
+	}
+}
+
+func TestImporter_SetPPS(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026336000000000
+cpu,host=server1 value=3 1464026337000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.PPS = 100
+	config.BatchSize = 1
+	var waits int
+	config.OnThrottle = func(time.Duration) { waits++ }
+	// Lift the limit once the first batch is written.
+	var i *v8.Importer
+	config.BeforeWrite = func(db, rp string, lines []string) error {
+		i.SetPPS(0)
+		return nil
+	}
+	i = v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Writes) != 3 || waits != 1 {
+		t.Fatalf("unexpected writes and throttle waits: %d, %d", len(s.Writes), waits)
+	}
+}
+
+func TestImporter_FlushInterval(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	written := make(chan string, 2)
+	s.WriteFn = func(w Write) error {
+		written <- w.Body
+		return nil
+	}
+
+	r, w := io.Pipe()
+	config := s.Config("")
+	config.Reader = r
+	config.FlushInterval = 20 * time.Millisecond
+	errc := make(chan error, 1)
+	go func() {
+		_, err := v8.NewImporter(config).Import()
+		errc <- err
+	}()
+
+	// The first point is written while the stream stalls.
+	fmt.Fprint(w, "# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\ncpu value=1 1\n")
+	select {
+	case body := <-written:
+		if body != "cpu value=1 1" {
+			t.Fatalf("unexpected write: %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("partial batch was not flushed")
+	}
+
+	fmt.Fprint(w, "cpu value=2 2\n")
+	w.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if body := <-written; body != "cpu value=2 2" {
+		t.Fatalf("unexpected write: %q", body)
+	}
+}
+
+func TestImporter_FlushInterval_ReverseTime(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	r, w := io.Pipe()
+	config := s.Config("")
+	config.Reader = r
+	config.FlushInterval = 20 * time.Millisecond
+	config.ReverseTime = true
+	errc := make(chan error, 1)
+	go func() {
+		_, err := v8.NewImporter(config).Import()
+		errc <- err
+	}()
+
+	// The points buffered for reversing stay buffered while the stream
+	// stalls, and are written newest first at the end.
+	fmt.Fprint(w, "# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\ncpu value=1 1\ncpu value=2 2\n")
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprint(w, "cpu value=3 3\n")
+	w.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Body != "cpu value=3 3\ncpu value=2 2\ncpu value=1 1" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_ImportContext(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026395000000000
+cpu,host=server1 value=3 1464026455000000000
+`)
+	defer os.Remove(path)
+
+	tests := []struct {
+		name          string
+		checks        int // checks of the context before it is canceled
+		flushOnCancel bool
+		queries       int
+		writes        []string
+	}{
+		{name: "before import", checks: 0},
+		{name: "during DDL", checks: 3, queries: 1},
+		{name: "during DML", checks: 9, queries: 1},
+		{name: "during DML with flush", checks: 9, flushOnCancel: true, queries: 1, writes: []string{
+			"cpu,host=server1 value=1 1464026335000000000\ncpu,host=server1 value=2 1464026395000000000",
+		}},
+	}
+
+	for _, tt := range tests {
+		s := NewServer()
+		config := s.Config(path)
+		config.FlushOnCancel = tt.flushOnCancel
+		_, err := v8.NewImporter(config).ImportContext(&cancelAfter{Context: context.Background(), n: tt.checks})
+		s.Close()
+		if err != context.Canceled {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if len(s.Queries) != tt.queries {
+			t.Errorf("%s: unexpected queries: %v", tt.name, s.Queries)
+		}
+		var writes []string
+		for _, w := range s.Writes {
+			writes = append(writes, w.Body)
+		}
+		if !reflect.DeepEqual(writes, tt.writes) {
+			t.Errorf("%s: unexpected writes: %q", tt.name, writes)
+		}
+	}
+}
+
+func TestImporter_ImportContext_Throttle(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	for _, flushOnCancel := range []bool{false, true} {
+		s := NewServer()
+		config := s.Config(path)
+		config.PPS = 1 // the first batch waits for a second
+		config.FlushOnCancel = flushOnCancel
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := v8.NewImporter(config).ImportContext(ctx)
+		elapsed := time.Since(start)
+		s.Close()
+
+		if err != context.Canceled {
+			t.Errorf("flush=%v: unexpected error: %v", flushOnCancel, err)
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("flush=%v: canceled import took %s", flushOnCancel, elapsed)
+		}
+		exp := 0
+		if flushOnCancel {
+			exp = 1
+		}
+		if len(s.Writes) != exp {
+			t.Errorf("flush=%v: unexpected writes: %v", flushOnCancel, s.Writes)
+		}
+	}
+}
+
+func TestImporter_Reader(t *testing.T) {
+	dump := `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(dump))
+	gw.Close()
+
+	for _, compressed := range []bool{false, true} {
+		s := NewServer()
+		config := s.Config("does-not-exist")
+		config.Compressed = compressed
+		if compressed {
+			config.Reader = bytes.NewReader(gz.Bytes())
+		} else {
+			config.Reader = strings.NewReader(dump)
+		}
+		_, err := v8.NewImporter(config).Import()
+		s.Close()
+		if err != nil {
+			t.Fatalf("compressed=%v: %s", compressed, err)
+		}
+		if len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000" {
+			t.Fatalf("compressed=%v: unexpected writes: %v", compressed, s.Writes)
+		}
+	}
+}
+
+func TestImporter_MaxFailures(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1
+cpu value=2
+cpu value=3
+cpu value=4
+cpu value=5
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	var attempts int
+	s.WriteFn = func(w Write) error {
+		attempts++
+		return errors.New("field type conflict")
+	}
+	config := s.Config(path)
+	config.BatchSize = 1
+	config.MaxFailures = 2
+	config.DeadLetterPath = path + ".dead" // keep failed lines off stdout
+	defer os.Remove(config.DeadLetterPath)
+	_, err := v8.NewImporter(config).Import()
+	if exp := "import aborted after 3 failed inserts, more than the maximum of 2"; err == nil || err.Error() != exp {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("unexpected write attempts: %d", attempts)
+	}
+}
+
+func TestImporter_ImportResult(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026395000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error {
+		if w.Database == "db1" {
+			return errors.New("database not found")
+		}
+		return nil
+	}
+	result, err := v8.NewImporter(s.Config(path)).Import()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	// db1, not created by the DDL, is created before its line is written
+	if result.TotalCommands != 2 || result.TotalInserts != 2 || result.FailedInserts != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if result.Duration <= 0 || result.PointsPerSecond <= 0 {
+		t.Fatalf("unexpected rate: %+v", result)
+	}
+	var total int
+	for _, n := range result.PointsBySecond {
+		total += n
+	}
+	if total != 2 || result.MaxPPS < result.MedianPPS || result.MedianPPS < result.MinPPS || result.MaxPPS == 0 {
+		t.Fatalf("unexpected throughput: %+v", result)
+	}
+
+	// Duplicates that are removed count as skipped.
+	duplicates := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026335000000000
+cpu,host=server2 value=3 1464026335000000000
+`)
+	defer os.Remove(duplicates)
+	config := s.Config(duplicates)
+	config.DuplicateStrategy = "skip"
+	result, err = v8.NewImporter(config).Import()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalInserts != 1 || result.Skipped != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestImporter_SummaryFormat(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value= 1464026395000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.ValidateLines = true
+	config.SummaryFormat = "json"
+	var buf bytes.Buffer
+	config.SummaryWriter = &buf
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatalf("invalid summary %q: %s", buf.String(), err)
+	}
+	for k, exp := range map[string]float64{"total_commands": 1, "total_inserts": 1, "failed_inserts": 0, "skipped": 1} {
+		if summary[k] != exp {
+			t.Errorf("unexpected %s: %v", k, summary[k])
+		}
+	}
+	if _, ok := summary["elapsed_seconds"]; !ok {
+		t.Errorf("missing elapsed_seconds: %v", summary)
+	}
+	if _, ok := summary["error"]; ok {
+		t.Errorf("unexpected error: %v", summary)
+	}
+}
+
+func TestImporter_Logger(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewServer()
+	defer s.Close()
+	var buf bytes.Buffer
+	config := s.Config(path)
+	config.Logger = log.New(&buf, "", 0)
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "Processed 1 inserts\n") {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+	if std.Len() != 0 {
+		t.Fatalf("unexpected standard log: %s", std.String())
+	}
+}
+
+func TestImporter_ResultsWriter(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+cpu,host=server1 value=43.3 1464026395000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=53.3 1464026455000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error {
+		if w.Database == "db1" {
+			return errors.New("database not found")
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	config := s.Config(path)
+	config.ResultsWriter = &buf
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	var got []v8.BatchResult
+	dec := json.NewDecoder(&buf)
+	for {
+		var r v8.BatchResult
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if r.Duration <= 0 {
+			t.Errorf("unexpected duration: %s", r.Duration)
+		}
+		r.Duration = 0
+		got = append(got, r)
+	}
+
+	exp := []v8.BatchResult{
+		{Batch: 1, Database: "db0", RetentionPolicy: "autogen", FirstLine: 7, LastLine: 8, Points: 2, Success: true},
+		{Batch: 2, Database: "db1", RetentionPolicy: "autogen", FirstLine: 10, LastLine: 10, Points: 1, Error: `{"error":"database not found"}` + "\n"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected results:\n\nexp=%+v\n\ngot=%+v", exp, got)
+	}
+}
+
+func TestImporter_ReverseTime(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=3 1464026455000000000
+cpu,host=server1 value=2 1464026395000000000
+# CONTEXT-DATABASE:db1
+mem,host=server1 free=1 1464026335
+mem,host=server1 free=2 1464026395
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.ReverseTime = true
+	config.MeasurementPrecision = map[string]string{"mem": "s"}
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, w := range s.Writes {
+		got = append(got, w.Database+": "+w.Body)
+	}
+	exp := []string{
+		"db0: cpu,host=server1 value=3 1464026455000000000\ncpu,host=server1 value=2 1464026395000000000\ncpu,host=server1 value=1 1464026335000000000",
+		"db1: mem,host=server1 free=2 1464026395\nmem,host=server1 free=1 1464026335",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%q\n\ngot=%q", exp, got)
+	}
+}
+
+func TestImporter_SortWindow(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1 0
+cpu value=3 30
+cpu value=2 10
+cpu value=5 70
+cpu value=4 65
+cpu value=0 -5
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.Precision = "s"
+	config.ReverseTime = true
+	config.SortWindow = time.Minute
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected writes: %#v", s.Writes)
+	}
+	exp := "cpu value=3 30\ncpu value=2 10\ncpu value=1 0\ncpu value=5 70\ncpu value=4 65\ncpu value=0 -5"
+	if got := s.Writes[0].Body; got != exp {
+		t.Fatalf("unexpected body:\n\nexp=%q\n\ngot=%q", exp, got)
+	}
+}
+
+func TestImporter_RunIDTag(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,import=old value=2 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.RunIDTag = "import"
+	config.RunID = "run1"
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "cpu,host=server1,import=run1 value=1 1464026335000000000\ncpu,import=old value=2 1464026335000000000"
+	if len(s.Writes) != 1 || s.Writes[0].Body != exp {
+		t.Fatalf("unexpected writes: %#v", s.Writes)
+	}
+
+	// Without a RunID one is generated.
+	config.RunID = ""
+	if id := v8.NewImporter(config).RunID(); id == "" {
+		t.Fatal("expected a generated run ID")
+	}
+}
+
+func TestImporter_MaxErrorsPerSecond(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+# CONTEXT-DATABASE:db2
+cpu,host=server1 value=3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error { return errors.New("write failed") }
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Keep the failed lines off stdout.
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+
+	config := s.Config(path)
+	config.MaxErrorsPerSecond = 1
+	config.DeadLetterPath = deadLetters
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if n := strings.Count(buf.String(), "error writing batch"); n != 1 {
+		t.Fatalf("unexpected logged error count: %d\n\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "Suppressed 2 error messages") {
+		t.Fatalf("missing suppressed error count:\n\n%s", buf.String())
+	}
+}
+
+func TestImporter_MeasurementProgress(t *testing.T) {
+	var dump bytes.Buffer
+	dump.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for _, name := range []string{"cpu", "mem"} {
+		for n := 0; n < 5000; n++ {
+			fmt.Fprintf(&dump, "%s,host=server1 value=%d %d\n", name, n, 1464026335000000000+n)
+		}
+	}
+	path := MustWriteDump(t, dump.String())
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	config := s.Config(path)
+	config.ProgressEveryLines = 5000
+	config.MeasurementProgress = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		`Measurement "cpu": 5000 points written`,
+		`Measurement "mem": 5000 points written`,
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("missing progress %q:\n\n%s", exp, buf.String())
+		}
+	}
+}
+
+func TestImporter_ProgressEveryLines(t *testing.T) {
+	var dump bytes.Buffer
+	dump.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 300; n++ {
+		fmt.Fprintf(&dump, "cpu,host=server1 value=%d %d\n", n, 1464026335000000000+n)
+	}
+	path := MustWriteDump(t, dump.String())
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	var buf bytes.Buffer
+	config := s.Config(path)
+	config.ProgressEveryLines = 100
+	config.Logger = log.New(&buf, "", 0)
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The whole dump fits in a single batch.
+	for _, n := range []int{100, 200, 300} {
+		if !strings.Contains(buf.String(), fmt.Sprintf("Processed %d lines.", n)) {
+			t.Fatalf("expected progress at %d lines, got:\n%s", n, buf.String())
+		}
+	}
+
+	// NoProgress leaves only the final call of ProgressFunc.
+	buf.Reset()
+	var calls int
+	config.ProgressEveryLines = v8.NoProgress
+	config.ProgressFunc = func(processed, failed int, pps float64) { calls++ }
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "Time elapsed") {
+		t.Fatalf("unexpected progress:\n%s", buf.String())
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected ProgressFunc calls: %d", calls)
+	}
+}
+
+func TestImporter_ProgressFunc(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026345000000000
+cpu,host=server1 value=3 1464026355000000000
+cpu,host=server1 value=4 1464026365000000000
+cpu,host=server1 value=5 1464026375000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error {
+		if strings.Contains(w.Body, "value=3") {
+			return errors.New("bad point")
+		}
+		return nil
+	}
+
+	var got [][2]int
+	config := s.Config(path)
+	config.BatchSize = 2
+	config.ProgressEveryLines = 2
+	config.ProgressFunc = func(processed, failed int, pps float64) {
+		if pps <= 0 {
+			t.Errorf("unexpected pps: %v", pps)
+		}
+		got = append(got, [2]int{processed, failed})
+	}
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if exp := [][2]int{{2, 0}, {4, 2}, {5, 2}}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected progress: %v", got)
+	}
+
+	// The final call counts the points processed like the ones before,
+	// even when some of them are not written.
+	duplicates := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026335000000000
+cpu,host=server1 value=3 1464026345000000000
+cpu,host=server1 value=4 1464026355000000000
+`)
+	defer os.Remove(duplicates)
+	s.WriteFn = nil
+	got = nil
+	config = s.Config(duplicates)
+	config.ProgressEveryLines = 2
+	config.DuplicateStrategy = "skip"
+	config.ProgressFunc = func(processed, failed int, pps float64) {
+		got = append(got, [2]int{processed, failed})
+	}
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := [][2]int{{2, 0}, {4, 0}, {4, 0}}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected progress: %v", got)
+	}
+}
+
+func TestImporter_CountLinesFirst(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+
+cpu,host=server1 value=2 1464026345000000000
+cpu,host=server1 value=3 1464026355000000000
+cpu,host=server1 value=4 1464026365000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	var buf bytes.Buffer
+	var percents []float64
+	config := s.Config(path)
+	config.BatchSize = 2
+	config.ProgressEveryLines = 2
+	config.CountLinesFirst = true
+	config.Logger = log.New(&buf, "", 0)
+	var i *v8.Importer
+	config.ProgressFunc = func(processed, failed int, pps float64) {
+		percents = append(percents, 100*float64(processed)/float64(i.TotalLines()))
+	}
+	i = v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []float64{50, 100, 100}; !reflect.DeepEqual(percents, exp) {
+		t.Fatalf("unexpected progress: %v", percents)
+	}
+	if !strings.Contains(buf.String(), "Processed 50.0% of 4 lines") {
+		t.Fatalf("expected a percentage, got:\n%s", buf.String())
+	}
+
+	// A dump that can only be read once is not counted.
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config.Path, config.Reader, config.ProgressFunc = "", f, nil
+	i = v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if n := i.TotalLines(); n != 0 {
+		t.Fatalf("unexpected total lines: %d", n)
+	}
+}
+
+func TestImporter_FailedTransient(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+cpu,host=server1 value=3 1464026395000000000
+# CONTEXT-DATABASE:db2
+cpu,host=server1 value=4 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.URL.Query().Get("db") {
+		case "db0":
+			http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+			return true
+		case "db1":
+			http.Error(w, `{"error":"timeout"}`, http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	}
+
+	config := s.Config(path)
+	config.DeadLetterPath = deadLetters
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err == nil {
+		t.Fatal("expected error")
+	}
+	if stats := i.Stats(); stats.Inserts != 1 || stats.Failed != 3 || stats.FailedTransient != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_WriteTimeout(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("db") != "db1" {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+		return false
+	}
+
+	config := s.Config(path)
+	config.DeadLetterPath = deadLetters
+	config.WriteTimeout = 50 * time.Millisecond
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err == nil {
+		t.Fatal("expected error")
+	}
+	if stats := i.Stats(); stats.Inserts != 1 || stats.Failed != 1 || stats.FailedTransient != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_UserAgent(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	var userAgent string
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		userAgent = r.UserAgent()
+		return false
+	}
+
+	config := s.Config(path)
+	config.Version = "1.2.3"
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "influxDB importer/1.2.3" {
+		t.Fatalf("unexpected user agent: %q", userAgent)
+	}
+
+	config.UserAgent = "my-proxy-client/1.0"
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "my-proxy-client/1.0" {
+		t.Fatalf("unexpected user agent: %q", userAgent)
+	}
+}
+
+func TestImporter_DowngradeConsistencyOnFailure(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("consistency") == "one" {
+			return false
+		}
+		http.Error(w, `{"error":"partial write"}`, http.StatusInternalServerError)
+		return true
+	}
+
+	// By default the batch fails.
+	config := s.Config(path)
+	config.WriteConsistency = "all"
+	config.DeadLetterPath = deadLetters
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	var buf bytes.Buffer
+	config.DowngradeConsistencyOnFailure = true
+	config.Logger = log.New(&buf, "", 0)
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Consistency != "one" {
+		t.Fatalf("unexpected writes: %+v", s.Writes)
+	}
+	if !strings.Contains(buf.String(), "Wrote 1 points at consistency one after partial writes at consistency all") {
+		t.Fatalf("expected the downgrade to be logged, got:\n%s", buf.String())
+	}
+
+	// A partial write rejected as a bad request is not retried.
+	var requests int
+	s.Writes = nil
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		requests++
+		http.Error(w, `{"error":"partial write: field type conflict"}`, http.StatusBadRequest)
+		return true
+	}
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+	if requests != 1 {
+		t.Fatalf("unexpected write requests: %d", requests)
+	}
+}
+
+func TestImporter_LoadQuery(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+
+	var checks int
+	heap := []int{900, 800, 100}
+	s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("q") != "SHOW STATS FOR 'runtime'" {
+			return false
+		}
+		if len(s.Writes) != 0 {
+			t.Error("write sent while the server was busy")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"results":[{"series":[{"name":"runtime","columns":["Alloc","HeapInUse"],"values":[[1,%d]]}]}]}`, heap[checks])
+		checks++
+		return true
+	}
+
+	config := s.Config(path)
+	config.LoadQuery = "SHOW STATS FOR 'runtime'"
+	config.LoadColumn = "HeapInUse"
+	config.LoadThreshold = 500
+	config.LoadBackoff = time.Millisecond
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if checks != 3 || len(s.Writes) != 1 {
+		t.Fatalf("unexpected checks=%d writes=%v", checks, s.Writes)
+	}
+
+	// A load that cannot be measured does not hold up writes.
+	s = NewServer()
+	defer s.Close()
+	config = s.Config(path)
+	config.LoadQuery = "SHOW STATS FOR 'runtime'"
+	config.LoadColumn = "HeapInUse"
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+
+	// Canceling ends the wait for a busy server at once.
+	s = NewServer()
+	defer s.Close()
+	s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("q") != "SHOW STATS FOR 'runtime'" {
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"results":[{"series":[{"name":"runtime","columns":["Alloc","HeapInUse"],"values":[[1,900]]}]}]}`)
+		return true
+	}
+	config = s.Config(path)
+	config.LoadQuery = "SHOW STATS FOR 'runtime'"
+	config.LoadColumn = "HeapInUse"
+	config.LoadThreshold = 500
+	config.LoadBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := v8.NewImporter(config).ImportContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("import took %s after being canceled", d)
+	}
+	if len(s.Writes) != 0 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_CreateRetentionPolicies(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-RETENTION-POLICY:one week
+cpu,host=server1 value=2 1464026335000000000
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=3 1464026335000000000
+# CONTEXT-RETENTION-POLICY:one week
+cpu,host=server1 value=4 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Query().Get("q"), "SHOW RETENTION POLICIES") {
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true]]}]}]}`))
+		return true
+	}
+
+	config := s.Config(path)
+	config.CreateRetentionPolicies = true
+	config.RetentionPolicyDuration = "1w"
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"CREATE DATABASE db0",
+		"SHOW RETENTION POLICIES ON db0",
+		`CREATE RETENTION POLICY "one week" ON db0 DURATION 1w REPLICATION 1`,
+	}
+	if !reflect.DeepEqual(s.Queries, exp) {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	if len(s.Writes) != 4 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_ContextWithColons(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE "ns:prod"
+
+# DML
+# CONTEXT-DATABASE:ns:prod
+# CONTEXT-RETENTION-POLICY: rp:1h
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Database != "ns:prod" || s.Writes[0].RetentionPolicy != "rp:1h" {
+		t.Fatalf("unexpected writes: %+v", s.Writes)
+	}
+}
+
+func TestImporter_CreateDatabases(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	// db0 is created by the DDL alone
+	if exp := []string{"CREATE DATABASE db0", "CREATE DATABASE db1"}; !reflect.DeepEqual(s.Queries, exp) {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	var writes []string
+	for _, w := range s.Writes {
+		writes = append(writes, w.Database+": "+w.Body)
+	}
+	exp := []string{
+		"db0: cpu,host=server1 value=1 1464026335000000000",
+		"db1: cpu,host=server1 value=2 1464026335000000000",
+		"db0: cpu,host=server1 value=3 1464026335000000000",
+	}
+	if !reflect.DeepEqual(writes, exp) {
+		t.Fatalf("unexpected writes: %q", writes)
+	}
+}
+
+func TestImporter_SkipDatabaseCreation(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+create database "db1"
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.SkipDatabaseCreation = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []string{"CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1"}; !reflect.DeepEqual(s.Queries, exp) {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Database != "db0" {
+		t.Fatalf("unexpected writes: %+v", s.Writes)
+	}
+}
+
+func TestImporter_RouteFunc(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+metrics.cpu value=1 1464026335000000000
+disk value=2 1464026335000000000
+metrics.mem value=3 1464026335000000000
+metrics.cpu value=4 1464026336000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.BatchSize = 2
+	config.RouteFunc = func(line string) (string, string) {
+		if strings.HasPrefix(line, "metrics.") {
+			return "metrics", ""
+		}
+		return "misc", "rp0"
+	}
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []string{"CREATE DATABASE db0", "CREATE DATABASE metrics", "CREATE DATABASE misc"}; !reflect.DeepEqual(s.Queries, exp) {
+		t.Fatalf("unexpected queries: %q", s.Queries)
+	}
+	var writes []string
+	for _, w := range s.Writes {
+		writes = append(writes, w.Database+"."+w.RetentionPolicy+": "+w.Body)
+	}
+	exp := []string{
+		"metrics.autogen: metrics.cpu value=1 1464026335000000000\nmetrics.mem value=3 1464026335000000000",
+		"metrics.autogen: metrics.cpu value=4 1464026336000000000",
+		"misc.rp0: disk value=2 1464026335000000000",
+	}
+	if !reflect.DeepEqual(writes, exp) {
+		t.Fatalf("unexpected writes: %q", writes)
+	}
+}
+
+func TestImporter_PostImportQueries(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	for _, failOnError := range []bool{false, true} {
+		s := NewServer()
+		s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Query().Get("q") != "SELECT count(value) FROM db0..cpu" {
+				return false
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{"error":"measurement not found"}]}`))
+			return true
+		}
+
+		config := s.Config(path)
+		config.PostImportQueries = []string{
+			"SELECT count(value) FROM db0..cpu",
+			"CREATE CONTINUOUS QUERY cq0 ON db0 BEGIN SELECT mean(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END",
+		}
+		config.PostImportFailOnError = failOnError
+		_, err := v8.NewImporter(config).Import()
+		s.Close()
+
+		exp := []string{"CREATE DATABASE db0", config.PostImportQueries[0], config.PostImportQueries[1]}
+		if failOnError {
+			if err == nil || err.Error() != `post-import query "SELECT count(value) FROM db0..cpu" failed: measurement not found` {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			exp = exp[:2]
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s.Queries, exp) {
+			t.Fatalf("failOnError=%v: unexpected queries: %q", failOnError, s.Queries)
+		}
+	}
+}
+
+func TestImporter_ImportFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, dump := range map[string]string{
+		"a.dump": `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+`,
+		"b.dump": `# DDL
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db1
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=2 1464026335000000000
+cpu,host=server1 value=3 1464026395000000000
+`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(dump), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := filepath.Join(dir, "missing", "c.dump")
+
+	s := NewServer()
+	defer s.Close()
+	s.WriteFn = func(w Write) error {
+		if w.Database == "db1" && strings.Contains(w.Body, "value=3") {
+			return errors.New("partial write")
+		}
+		return nil
+	}
+
+	deadLetters := MustWriteDump(t, "")
+	defer os.Remove(deadLetters)
+
+	config := s.Config("")
+	config.Paths = []string{dir, missing, filepath.Join(dir, "*.dump")}
+	config.DeadLetterPath = deadLetters
+	files, total, err := v8.NewImporter(config).ImportFiles(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "b.dump")+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("unexpected file count: %d", len(files))
+	}
+	for n, exp := range []v8.FileStats{
+		{Path: filepath.Join(dir, "a.dump"), Stats: v8.Stats{Commands: 1, Inserts: 1, WriteRequests: 1, PartialBatches: 1}},
+		{Path: filepath.Join(dir, "b.dump"), Stats: v8.Stats{Commands: 1, Failed: 2, WriteRequests: 1, PartialBatches: 1}},
+	} {
+		got := files[n]
+		if got.Path != exp.Path || got.Stats != exp.Stats || got.Elapsed <= 0 {
+			t.Errorf("unexpected result for %s: %+v", exp.Path, got)
+		}
+	}
+	if files[0].Err != nil || files[1].Err == nil || files[1].Err.Error() != "2 points were not inserted" {
+		t.Errorf("unexpected errors: %v, %v", files[0].Err, files[1].Err)
+	}
+	if total != (v8.Stats{Commands: 2, Inserts: 1, Failed: 2, WriteRequests: 2, PartialBatches: 2}) {
+		t.Errorf("unexpected totals: %+v", total)
+	}
+
+	// The failing files are skipped with ContinueOnFileError.
+	config.ContinueOnFileError = true
+	files, total, err = v8.NewImporter(config).ImportFiles(context.Background())
+	if err == nil || err.Error() != "3 of 5 files failed to import" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var paths []string
+	for _, fs := range files {
+		if fs.Err != nil {
+			paths = append(paths, fs.Path)
+		}
+	}
+	if exp := []string{filepath.Join(dir, "b.dump"), missing, filepath.Join(dir, "b.dump")}; !reflect.DeepEqual(paths, exp) {
+		t.Errorf("unexpected failed files: %q", paths)
+	}
+	if total != (v8.Stats{Commands: 4, Inserts: 2, Failed: 4, WriteRequests: 4, PartialBatches: 4}) {
+		t.Errorf("unexpected totals: %+v", total)
+	}
+
+	// A file stopped by an error does not stop the files after it.
+	stopped := filepath.Join(dir, "stopped.dump")
+	if err := ioutil.WriteFile(stopped, []byte("# DDL\nCREATE DATABASE bad\n\n# DML\n# CONTEXT-DATABASE:bad\n# CONTEXT-RETENTION-POLICY:autogen\ncpu value=1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s.QueryHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("q") != "CREATE DATABASE bad" {
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"error":"denied"}]}`))
+		return true
+	}
+	s.Writes = nil
+	config.Paths = []string{stopped, filepath.Join(dir, "a.dump")}
+	config.StopOnDDLError = true
+	files, _, err = v8.NewImporter(config).ImportFiles(context.Background())
+	if err == nil || err.Error() != "1 of 2 files failed to import" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files[0].Err == nil || files[0].Err.Error() != `error executing "CREATE DATABASE bad": denied` || files[1].Err != nil {
+		t.Errorf("unexpected errors: %v, %v", files[0].Err, files[1].Err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Database != "db0" {
+		t.Errorf("unexpected writes: %+v", s.Writes)
+	}
+}
+
+func TestImporter_BatchFill(t *testing.T) {
+	var dump bytes.Buffer
+	dump.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 5002; n++ {
+		fmt.Fprintf(&dump, "cpu,host=server1 value=%d %d\n", n, 1464026335000000000+n)
+	}
+	path := MustWriteDump(t, dump.String())
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	var attempts int
+	s.WriteHandler = func(w http.ResponseWriter, r *http.Request) bool {
+		if attempts++; attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return true
+		}
+		return false
+	}
+
+	i := v8.NewImporter(s.Config(path))
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := i.Stats(); stats.WriteRequests != 3 || stats.FullBatches != 1 || stats.PartialBatches != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_SeriesManifestPath(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server2 value=2 1464026335000000000
+cpu,host=server1 value=3 1464026395000000000
+mem,host=server1 free=1 1464026335000000000
+`)
+	defer os.Remove(path)
+	manifestPath := MustWriteDump(t, "")
+	defer os.Remove(manifestPath)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.SeriesManifestPath = manifestPath
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := i.Stats(); stats.Series != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	b, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "cpu,host=server1\ncpu,host=server2\nmem,host=server1\n"; string(b) != exp {
+		t.Fatalf("unexpected manifest: %q", b)
+	}
+}
+
+func TestImporter_DetectCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(`# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`))
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := MustWriteDump(t, buf.String())
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	if _, err := v8.NewImporter(s.Config(path)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Queries) != 1 || len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000" {
+		t.Fatalf("unexpected requests: %v %v", s.Queries, s.Writes)
+	}
+}
+
+func TestImporter_ReadError(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(`# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`))
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Truncated, the dump fails partway through
+	path := MustWriteDump(t, buf.String()[:buf.Len()-8])
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	_, err := v8.NewImporter(s.Config(path)).Import()
+	if err == nil || !strings.Contains(err.Error(), "reading "+path+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A file of a split dump is named too
+	config := s.Config("")
+	config.SplitPaths = []string{path}
+	_, err = v8.NewImporter(config).Import()
+	if err == nil || !strings.Contains(err.Error(), "reading split dump: "+path+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestImporter_CompressionFormat_Bzip2(t *testing.T) {
+	// A bzip2 compressed dump writing one point to db0
+	data, err := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWay7jNYAAAlfgAIQSAd/Ej4n3GA+xd8AIAByIoaAGgaANAAPUaeoNT0pHlDamnqPUfqgPU9Q8oAaHBSllnh7gpBe0C+e8utoCIx+QwRdrVDci46Z+98BwHEYZCAWaSLTiqKoSJHo2hLH1tqHk3DqCxs2EGi47BCDai2Xw+JSpRg3cdgIn4x/F3JFOFCQrLuM1g==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := MustWriteDump(t, string(data))
+	defer os.Remove(path)
+
+	for _, format := range []v8.CompressionFormat{v8.CompressionAuto, v8.CompressionBzip2} {
+		s := NewServer()
+		config := s.Config(path)
+		config.CompressionFormat = format
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Queries) != 1 || len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000" {
+			t.Fatalf("unexpected requests with format %d: %v %v", format, s.Queries, s.Writes)
+		}
+		s.Close()
+	}
+
+	// Read as uncompressed, the dump is refused
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.CompressionFormat = v8.CompressionNone
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestImporter_CompressionFormat_Zstd(t *testing.T) {
+	// A zstd compressed dump writing one point to db0
+	data, err := base64.StdEncoding.DecodeString("KLUv/SSLpQMA0gYZGUDVA+xeTDLV8ClFhNkTk9bfWH8XOzoqMAFQGnMehCyOYvgpzJnwjknfDxz/ON4lXqddQN8omHFL87qERHXBugmyXna++zpIsKG7s64fIWbsRwjlVP19qEI3tX83vU6F2NqPAQQAwEtCpxEA3AVClQHZhPXI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := MustWriteDump(t, string(data))
+	defer os.Remove(path)
+
+	for _, format := range []v8.CompressionFormat{v8.CompressionAuto, v8.CompressionZstd} {
+		s := NewServer()
+		config := s.Config(path)
+		config.CompressionFormat = format
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Queries) != 1 || len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000" {
+			t.Fatalf("unexpected requests with format %d: %v %v", format, s.Queries, s.Writes)
+		}
+		s.Close()
+	}
+}
+
+func TestImporter_URL(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(`# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`))
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dumps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer dumps.Close()
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(dumps.URL + "/dump.gz")
+	config.PathBearerToken = "secret"
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Queries) != 1 || len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000" {
+		t.Fatalf("unexpected requests: %v %v", s.Queries, s.Writes)
+	}
+
+	config.PathBearerToken = ""
+	if _, err := v8.NewImporter(config).Import(); err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestImporter_Import_URL_TLS(t *testing.T) {
+	dump := []byte(`# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	dumps := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(dump)
+	}))
+	defer dumps.Close()
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(dumps.URL + "/dump")
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected the self-signed certificate to be rejected")
+	}
+
+	// The TLS settings of the client apply to the download.
+	config.UnsafeSsl = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+
+	// So does the timeout, while waiting for the response.
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	config = s.Config(slow.URL + "/dump")
+	config.WriteTimeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := v8.NewImporter(config).Import(); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("download took %s to time out", d)
+	}
+}
+
+func TestImporter_Import_SplitPaths(t *testing.T) {
+	first := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000`)
+	defer os.Remove(first)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte("cpu,host=server1 value=2 1464026345000000000\n"))
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	second := MustWriteDump(t, buf.String())
+	defer os.Remove(second)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config("")
+	config.SplitPaths = []string{first, second}
+	res, err := v8.NewImporter(config).Import()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TotalInserts != 2 {
+		t.Fatalf("unexpected inserts: %d", res.TotalInserts)
+	}
+	exp := "cpu,host=server1 value=1 1464026335000000000\ncpu,host=server1 value=2 1464026345000000000"
+	if len(s.Queries) != 1 || len(s.Writes) != 1 || s.Writes[0].Body != exp {
+		t.Fatalf("unexpected requests: %v %v", s.Queries, s.Writes)
+	}
+
+	missing := first + ".missing"
+	config.SplitPaths = []string{first, missing}
+	if _, err := v8.NewImporter(config).Import(); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Paths are separate dumps for ImportFiles, not read by Import.
+	config.SplitPaths = nil
+	config.Paths = []string{first, second}
+	if _, err := v8.NewImporter(config).Import(); err != v8.ErrFileRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestImporter_InvalidWriteOptions(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1
+`)
+	defer os.Remove(path)
+
+	for _, tt := range []struct {
+		precision, consistency, err string
+	}{
+		{precision: "sec", err: `invalid precision "sec", expected ns, u, ms, s, m or h`},
+		{consistency: "most", err: `invalid write consistency "most", expected any, one, quorum or all`},
+	} {
+		s := NewServer()
+		config := s.Config(path)
+		config.Precision = tt.precision
+		config.WriteConsistency = tt.consistency
+		_, err := v8.NewImporter(config).Import()
+		s.Close()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if len(s.Queries) != 0 || len(s.Writes) != 0 {
+			t.Errorf("unexpected requests: %v %v", s.Queries, s.Writes)
+		}
+	}
+}
+
+func TestImporter_MaxBatchBytes(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu value=1
+cpu value=2
+cpu,host=a_very_long_host_name value=3
+cpu value=4
+cpu value=5
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.MaxBatchBytes = 24 // two short lines and their newline
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	var bodies []string
+	for _, w := range s.Writes {
+		bodies = append(bodies, w.Body)
+	}
+	exp := []string{
+		"cpu value=1\ncpu value=2",
+		"cpu,host=a_very_long_host_name value=3",
+		"cpu value=4\ncpu value=5",
+	}
+	if !reflect.DeepEqual(bodies, exp) {
+		t.Fatalf("unexpected writes: %q", bodies)
+	}
+	if stats := i.Stats(); stats.FullBatches != 2 || stats.PartialBatches != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_Errors(t *testing.T) {
+	s := NewServer()
+	config := s.Config("")
+	if _, err := v8.NewImporter(config).Import(); err != v8.ErrFileRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.Close()
+	config.Path = "dump.txt"
+	_, err := v8.NewImporter(config).Import()
+	if e, ok := err.(*v8.ConnectionError); !ok || e.Addr != s.URL || e.Err == nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
+
+func TestImporter_Stats_Concurrent(t *testing.T) {
+	var dump bytes.Buffer
+	dump.WriteString("# DDL\nCREATE DATABASE db0\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 100; n++ {
+		fmt.Fprintf(&dump, "cpu,host=server%d value=%d 1464026335000000000\n", n%10, n)
+	}
+	path := MustWriteDump(t, dump.String())
+	defer os.Remove(path)
+	manifest := path + ".series"
+	defer os.Remove(manifest)
+
+	config := v8.NewConfig()
+	config.Path = path
+	config.Sink = &slowSink{}
+	config.BatchSize = 10
+	config.Concurrency = 2
+	config.DedupeDDL = true
+	config.SeriesManifestPath = manifest
+	i := v8.NewImporter(config)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			stats := i.Stats()
+			if stats.Inserts == 100 {
+				return
+			}
+			if stats.Inserts > 100 || stats.FullBatches > 10 {
+				t.Errorf("unexpected stats: %+v", stats)
+				return
+			}
+		}
+	}()
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if stats := i.Stats(); stats.Commands != 1 || stats.DedupedDDL != 1 || stats.Series != 10 || stats.FullBatches != 10 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_Close(t *testing.T) {
+	path := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	i := v8.NewImporter(s.Config(path))
+	for n := 0; n < 2; n++ {
+		result, err := i.Import()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.TotalCommands != 1 || result.TotalInserts != 1 {
+			t.Fatalf("import %d: unexpected result: %+v", n, result)
+		}
+		if err := i.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.Writes) != 2 {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_Close_Canceled(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 100; n++ {
+		fmt.Fprintf(&buf, "cpu,host=server%d value=%d 1464026335000000000\n", n, n)
+	}
+	path := MustWriteDump(t, buf.String())
+	defer os.Remove(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer()
+	defer s.Close()
+	var once sync.Once
+	var inFlight int32
+	s.WriteFn = func(w Write) error {
+		atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		once.Do(cancel)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	config := s.Config(path)
+	config.BatchSize = 10
+	config.Concurrency = 4
+	config.FlushOnCancel = true
+	i := v8.NewImporter(config)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		i.ImportContext(ctx)
+	}()
+
+	// Closing right after canceling waits for the batches still written.
+	<-ctx.Done()
+	if err := i.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&inFlight); n != 0 {
+		t.Fatalf("Close returned with %d writes in flight", n)
+	}
+	<-done
+}
+
+func TestImporter_BatchSize(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+cpu,host=server1 value=2 1464026345000000000
+cpu,host=server1 value=3 1464026355000000000
+cpu,host=server1 value=4 1464026365000000000
+cpu,host=server1 value=5 1464026375000000000
+`)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.BatchSize = 2
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	for _, w := range s.Writes {
+		sizes = append(sizes, strings.Count(w.Body, "\n")+1)
+	}
+	if exp := []int{2, 2, 1}; !reflect.DeepEqual(sizes, exp) {
+		t.Fatalf("unexpected batch sizes: %v", sizes)
+	}
+	if stats := i.Stats(); stats.FullBatches != 2 || stats.PartialBatches != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestImporter_Concurrency(t *testing.T) {
+	var dump bytes.Buffer
+	dump.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:autogen\n")
+	for n := 0; n < 8; n++ {
+		fmt.Fprintf(&dump, "cpu,host=server1 value=%d %d\n", n, 1464026335000000000+n)
+	}
+	path := MustWriteDump(t, dump.String())
+	defer os.Remove(path)
+
+	sink := &slowSink{delay: 20 * time.Millisecond}
+	config := v8.NewConfig()
+	config.Path = path
+	config.Sink = sink
+	config.BatchSize = 1
+	config.Concurrency = 4
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := i.Stats(); stats.Inserts != 8 || stats.WriteRequests != 8 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(sink.lines) != 8 {
+		t.Fatalf("unexpected lines: %q", sink.lines)
+	}
+	if sink.maxInFlight < 2 || sink.maxInFlight > 4 {
+		t.Fatalf("unexpected concurrent writes: %d", sink.maxInFlight)
+	}
+}
+
+// slowSink is a Sink that takes a while to write each batch, recording how
+// many batches it was writing at once.
+type slowSink struct {
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	lines       []string
+}
+
+func (s *slowSink) WriteBatch(database, retentionPolicy string, lines []string) error {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.lines = append(s.lines, lines...)
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *slowSink) Close() error { return nil }
+
+func TestImporter_Client(t *testing.T) {
+	path := MustWriteDump(t, `# DDL
+CREATE DATABASE db0
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-RETENTION-POLICY:autogen
+# CONTEXT-DATABASE:db0
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-DATABASE:db1
+cpu,host=server1 value=2 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	c := &fakeClient{fail: "db1"}
+	config := v8.NewConfig()
+	config.Path = path
+	config.Client = c
+	i := v8.NewImporter(config)
+	if _, err := i.Import(); err == nil || err.Error() != "1 point was not inserted" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"CREATE DATABASE db0", "CREATE DATABASE db1"}; !reflect.DeepEqual(c.queries, exp) {
+		t.Fatalf("unexpected queries: %q", c.queries)
+	}
+	if exp := []string{"db0: cpu,host=server1 value=1 1464026335000000000"}; !reflect.DeepEqual(c.writes, exp) {
+		t.Fatalf("unexpected writes: %q", c.writes)
+	}
+}
+
+// fakeClient is a v8.Client recording queries and writes, which fail for
+// the database named by fail.
+type fakeClient struct {
+	fail    string
+	queries []string
+	writes  []string
+}
+
+func (c *fakeClient) Ping() (time.Duration, string, error) { return 0, "", nil }
+func (c *fakeClient) Addr() string                         { return "fake" }
+
+func (c *fakeClient) Query(q client.Query) (*client.Response, error) {
+	c.queries = append(c.queries, q.Command)
+	return &client.Response{}, nil
+}
+
+func (c *fakeClient) WriteLineProtocol(data, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error) {
+	if database == c.fail {
+		return nil, errors.New("write failed")
+	}
+	c.writes = append(c.writes, database+": "+data)
+	return nil, nil
+}
+
+func (c *fakeClient) WriteLineProtocolReader(r io.Reader, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.WriteLineProtocol(string(data), database, retentionPolicy, precision, writeConsistency)
+}
+
+func (c *fakeClient) WriteBucketLineProtocolReader(r io.Reader, org, bucket, precision string) (*client.Response, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestImporter_BinaryInput(t *testing.T) {
+	for _, tt := range []struct {
+		name, content, err string
+	}{
+		{name: "null bytes", content: "# DDL\n\x00\x01\x02\x03", err: "input does not appear to be a text dump"},
+		{name: "invalid utf-8", content: "\xff\xfe\xfd# DDL\n", err: "input does not appear to be a text dump"},
+	} {
+		path := MustWriteDump(t, tt.content)
+		defer os.Remove(path)
+
+		s := NewServer()
+		defer s.Close()
+		_, err := v8.NewImporter(s.Config(path)).Import()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if len(s.Queries) != 0 || len(s.Writes) != 0 {
+			t.Errorf("%s: unexpected requests: %v %v", tt.name, s.Queries, s.Writes)
+		}
+	}
+}
+
+func TestImporter_CompressedMultistream(t *testing.T) {
+	// Dumps appended to one another are gzip files with several members.
+	var buf bytes.Buffer
+	for _, member := range []string{`# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=33.3 1464026335000000000
+`, `cpu,host=server1 value=43.3 1464026395000000000
+`} {
+		gw := gzip.NewWriter(&buf)
+		gw.Write([]byte(member))
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := MustWriteDump(t, buf.String())
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := s.Config(path)
+	config.Compressed = true
+	if _, err := v8.NewImporter(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Writes) != 1 || s.Writes[0].Body != "cpu,host=server1 value=33.3 1464026335000000000\ncpu,host=server1 value=43.3 1464026395000000000" {
+		t.Fatalf("unexpected writes: %v", s.Writes)
+	}
+}
+
+func TestImporter_BucketFunc(t *testing.T) {
+	path := MustWriteDump(t, `
+# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:autogen
+cpu,host=server1 value=1 1464026335000000000
+# CONTEXT-RETENTION-POLICY:rp1
+cpu,host=server1 value=2 1464026335000000000
+`)
+	defer os.Remove(path)
+
+	for _, stream := range []bool{false, true} {
+		s := NewServer()
+		config := s.Config(path)
+		config.StreamWrites = stream
+		config.BucketFunc = func(db, rp string) (string, string) {
+			return "org0", db + "/" + rp
+		}
+		if _, err := v8.NewImporter(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		exp := []Write{
+			{Org: "org0", Bucket: "db0/autogen", Precision: "ns", Body: "cpu,host=server1 value=1 1464026335000000000"},
+			{Org: "org0", Bucket: "db0/rp1", Precision: "ns", Body: "cpu,host=server1 value=2 1464026335000000000"},
+		}
+		if !reflect.DeepEqual(s.Writes, exp) {
+			t.Fatalf("stream=%v: unexpected writes: %+v", stream, s.Writes)
+		}
+	}
+}
+
+// cancelAfter is a context that is canceled once Err has been called n times.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n == 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+// Server is a test InfluxDB server that records the queries and writes it receives.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	Queries []string
+	Writes  []Write
+
+	// WriteFn, if set, is called for every write. Returning an error fails the write.
+	WriteFn func(w Write) error
+
+	// WriteHandler, if set, is called before a write is recorded. Returning
+	// true means it handled the request and the write is not recorded.
+	WriteHandler func(w http.ResponseWriter, r *http.Request) bool
+
+	// QueryHandler, if set, is called after a query is recorded. Returning
+	// true means it handled the request.
+	QueryHandler func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// Write is a single write request received by Server.
+type Write struct {
+	Database        string
+	RetentionPolicy string
+	Precision       string
+	Consistency     string
+	Org             string
+	Bucket          string
+	Body            string
+}
+
+// NewServer returns a running instance of Server.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Config returns an importer config pointing at s which imports the dump at path.
+func (s *Server) Config(path string) v8.Config {
+	u, _ := url.Parse(s.URL)
+	config := v8.NewConfig()
+	config.URL = *u
+	config.Path = path
+	return config
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/ping":
+		w.WriteHeader(http.StatusNoContent)
+	case "/query":
+		s.Queries = append(s.Queries, r.URL.Query().Get("q"))
+		if s.QueryHandler != nil && s.QueryHandler(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{}]}`))
+	case "/write", "/api/v2/write":
+		if s.WriteHandler != nil && s.WriteHandler(w, r) {
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		params := r.URL.Query()
+		wr := Write{
+			Database:        params.Get("db"),
+			RetentionPolicy: params.Get("rp"),
+			Precision:       params.Get("precision"),
+			Consistency:     params.Get("consistency"),
+			Org:             params.Get("org"),
+			Bucket:          params.Get("bucket"),
+			Body:            string(body),
+		}
+		if s.WriteFn != nil {
+			if err := s.WriteFn(wr); err != nil {
+				http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		s.Writes = append(s.Writes, wr)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// MustWriteDump writes content to a temporary file and returns its path.
+func MustWriteDump(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "influxdb-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.TrimLeft(content, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
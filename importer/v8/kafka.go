@@ -0,0 +1,50 @@
+// +build kafka
+
+package v8
+
+import (
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+func init() {
+	newKafkaSink = func(brokers []string, topic string) (Sink, error) {
+		return NewKafkaSink(brokers, topic)
+	}
+}
+
+// KafkaSink is a Sink that publishes every batch to a Kafka topic as a single
+// message of newline separated line protocol, keyed by the database.
+type KafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaSink returns a sink publishing to topic on the given brokers.
+func NewKafkaSink(brokers []string, topic string) (*KafkaSink, error) {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaSink{producer: producer, topic: topic}, nil
+}
+
+// WriteBatch publishes lines and waits for the brokers to acknowledge them.
+// The retention policy is not part of the message.
+func (s *KafkaSink) WriteBatch(database, retentionPolicy string, lines []string) error {
+	_, _, err := s.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: s.topic,
+		Key:   sarama.StringEncoder(database),
+		Value: sarama.StringEncoder(strings.Join(lines, "\n")),
+	})
+	return err
+}
+
+// Close closes the producer.
+func (s *KafkaSink) Close() error {
+	return s.producer.Close()
+}
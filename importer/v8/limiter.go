@@ -0,0 +1,49 @@
+package v8
+
+import "time"
+
+// limiter is a token bucket limiting the points written per second. It
+// starts empty and refills at rate points per second up to burst points, so
+// that no more than one batch is written at once after a pause.
+type limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter allowing pps points per second, or nil if pps
+// is not positive, meaning no limit.
+func newLimiter(pps, burst int) *limiter {
+	if pps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{rate: float64(pps), burst: float64(burst), last: time.Now()}
+}
+
+// reserve takes n points from the bucket at time now and returns how long
+// to wait before writing them. A batch larger than the bucket leaves it in
+// debt, delaying the batches after it.
+func (l *limiter) reserve(n int, now time.Time) time.Duration {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// setRate changes the rate to pps points per second from time now on.
+func (l *limiter) setRate(pps int, now time.Time) {
+	l.reserve(0, now)
+	l.rate = float64(pps)
+}
@@ -0,0 +1,39 @@
+package v8
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	if l := newLimiter(0, 10); l != nil {
+		t.Fatalf("expected no limiter without a rate")
+	}
+
+	l := newLimiter(100, 10)
+	start := l.last
+	tests := []struct {
+		n     int
+		after time.Duration
+		wait  time.Duration
+	}{
+		{n: 10, after: 0, wait: 100 * time.Millisecond},                      // starts empty
+		{n: 10, after: 100 * time.Millisecond, wait: 100 * time.Millisecond}, // still paying for the first
+		{n: 5, after: time.Second, wait: 0},                                  // refilled to the burst
+		{n: 20, after: time.Second, wait: 150 * time.Millisecond},            // larger than the burst
+		{n: 1, after: time.Second + 150*time.Millisecond, wait: 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		wait := l.reserve(tt.n, start.Add(tt.after))
+		if d := wait - tt.wait; d < -time.Microsecond || d > time.Microsecond {
+			t.Fatalf("reserve(%d) after %s: got wait %s, expected %s", tt.n, tt.after, wait, tt.wait)
+		}
+	}
+
+	// The debt of the last point is paid back at the new rate.
+	now := start.Add(time.Second + 150*time.Millisecond)
+	l.setRate(10, now)
+	if wait := l.reserve(0, now); wait != 100*time.Millisecond {
+		t.Fatalf("unexpected wait after setRate: %s", wait)
+	}
+}
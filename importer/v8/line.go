@@ -0,0 +1,321 @@
+package v8
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+)
+
+// splitLine splits a line of line protocol into its series key, field set and
+// timestamp sections. ts is empty when the point has no explicit timestamp.
+func splitLine(line string) (key, fields, ts string) {
+	n := scanTo(line, 0, ' ', false)
+	key = line[:n]
+	if n >= len(line) {
+		return key, "", ""
+	}
+
+	start := n + 1
+	n = scanTo(line, start, ' ', true)
+	fields = line[start:n]
+	if n < len(line) {
+		ts = strings.TrimSpace(line[n+1:])
+	}
+	return key, fields, ts
+}
+
+// joinLine is the inverse of splitLine.
+func joinLine(key, fields, ts string) string {
+	if fields == "" {
+		return key
+	} else if ts == "" {
+		return key + " " + fields
+	}
+	return key + " " + fields + " " + ts
+}
+
+// scanTo returns the index of the first unescaped occurrence of c in s at or
+// after start, or len(s) if there is none. When quoted is true, occurrences
+// inside double quoted strings are ignored.
+func scanTo(s string, start int, c byte, quoted bool) int {
+	inQuote := false
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == c && !inQuote:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// linesReader reads a slice of lines joined by newlines without building the
+// joined string in memory.
+type linesReader struct {
+	lines []string
+	n     int // index of the line being read
+	off   int // offset into the line being read
+	size  int // number of bytes not yet read
+}
+
+// newLinesReader returns a reader over lines joined by newlines.
+func newLinesReader(lines []string) *linesReader {
+	r := &linesReader{lines: lines}
+	for _, line := range lines {
+		r.size += len(line) + 1
+	}
+	if r.size > 0 {
+		r.size-- // no trailing newline
+	}
+	return r
+}
+
+// Len returns the number of bytes not yet read.
+func (r *linesReader) Len() int { return r.size }
+
+// Read implements io.Reader.
+func (r *linesReader) Read(p []byte) (int, error) {
+	if r.size == 0 {
+		return 0, io.EOF
+	}
+
+	var n int
+	for n < len(p) && r.size > 0 {
+		line := r.lines[r.n]
+		if r.off < len(line) {
+			c := copy(p[n:], line[r.off:])
+			r.off += c
+			r.size -= c
+			n += c
+			continue
+		}
+		p[n] = '\n'
+		n++
+		r.size--
+		r.n++
+		r.off = 0
+	}
+	return n, nil
+}
+
+// measurementName returns the unescaped measurement name of a series key.
+func measurementName(key string) string {
+	return escape.UnescapeString(key[:scanTo(key, 0, ',', false)])
+}
+
+// splitFields splits the field set of a line into its key=value pairs.
+func splitFields(fields string) []string {
+	return split(fields, ',', true)
+}
+
+// splitTags splits a series key into its measurement and tag key=value pairs.
+func splitTags(key string) []string {
+	return split(key, ',', false)
+}
+
+// split splits s at every unescaped occurrence of c, ignoring occurrences
+// inside double quoted strings when quoted is true.
+func split(s string, c byte, quoted bool) []string {
+	if s == "" {
+		return nil
+	}
+
+	var a []string
+	for start := 0; start <= len(s); {
+		n := scanTo(s, start, c, quoted)
+		a = append(a, s[start:n])
+		start = n + 1
+	}
+	return a
+}
+
+// fieldKey returns the unescaped key of a field key=value pair.
+func fieldKey(pair string) string {
+	return escape.UnescapeString(pair[:scanTo(pair, 0, '=', false)])
+}
+
+// fieldEdit is a change made to a field by Config.DropFields,
+// CoerceFloatFields or CoerceIntFields.
+type fieldEdit int
+
+const (
+	dropField fieldEdit = iota + 1
+	floatField
+	intField
+)
+
+// editFields drops or coerces the fields of line named in edits. It returns
+// false if no field is left.
+func editFields(line string, edits map[string]fieldEdit) (string, bool) {
+	key, fields, ts := splitLine(line)
+	pairs := splitFields(fields)
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		switch edits[fieldKey(pair)] {
+		case dropField:
+			continue
+		case floatField:
+			pair = coerceField(pair, false)
+		case intField:
+			pair = coerceField(pair, true)
+		}
+		kept = append(kept, pair)
+	}
+	if len(kept) == 0 {
+		return "", false
+	}
+	return joinLine(key, strings.Join(kept, ","), ts), true
+}
+
+// coerceField converts the numeric value of a key=value pair to an integer,
+// truncating it, or to a float. Other values are left alone.
+func coerceField(pair string, toInt bool) string {
+	eq := scanTo(pair, 0, '=', false)
+	if eq >= len(pair) {
+		return pair
+	}
+	value := pair[eq+1:]
+	isInt := strings.HasSuffix(value, "i")
+	if isInt {
+		value = value[:len(value)-1]
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return pair
+	}
+	switch {
+	case toInt && !isInt:
+		return pair[:eq+1] + strconv.FormatInt(int64(f), 10) + "i"
+	case !toInt && isInt:
+		return pair[:eq+1] + value
+	}
+	return pair
+}
+
+// addTag adds the tag key=value to line, unless line already has the tag.
+func addTag(line, key, value string) string {
+	k, fields, ts := splitLine(line)
+	tags := splitTags(k)
+	if len(tags) == 0 {
+		return line
+	}
+	for _, t := range tags[1:] {
+		if escape.UnescapeString(t[:scanTo(t, 0, '=', false)]) == key {
+			return line
+		}
+	}
+	return joinLine(k+","+escape.String(key)+"="+escape.String(value), fields, ts)
+}
+
+// setTags adds tags to line in key order. Tags that line already has keep
+// their value unless overwrite is set.
+func setTags(line string, tags map[string]string, overwrite bool) string {
+	k, fields, ts := splitLine(line)
+	pairs := splitTags(k)
+	if len(pairs) == 0 {
+		return line
+	}
+
+	seen := make(map[string]bool, len(tags))
+	for n, pair := range pairs[1:] {
+		key := escape.UnescapeString(pair[:scanTo(pair, 0, '=', false)])
+		value, ok := tags[key]
+		if !ok {
+			continue
+		}
+		seen[key] = true
+		if overwrite {
+			pairs[n+1] = escape.String(key) + "=" + escape.String(value)
+		}
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs = append(pairs, escape.String(key)+"="+escape.String(tags[key]))
+	}
+	return joinLine(strings.Join(pairs, ","), fields, ts)
+}
+
+// shiftTimestamp adds d to the timestamp of line, interpreting the timestamp
+// in the given precision. Lines without a timestamp, or with one that cannot
+// be parsed, are returned unchanged.
+func shiftTimestamp(line string, d time.Duration, precision string) string {
+	key, fields, ts := splitLine(line)
+	if ts == "" {
+		return line
+	}
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return line
+	}
+	n += int64(d) / models.GetPrecisionMultiplier(precision)
+	return joinLine(key, fields, strconv.FormatInt(n, 10))
+}
+
+// timestamp returns the timestamp of line in nanoseconds, or false if it has
+// none.
+func timestamp(line, precision string) (int64, bool) {
+	_, _, ts := splitLine(line)
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * models.GetPrecisionMultiplier(precision), true
+}
+
+// timedLine is a line held back to be written in timestamp order.
+type timedLine struct {
+	line            string
+	database        string
+	retentionPolicy string
+	precision       string
+	lineNum         int
+	ts              int64 // nanoseconds
+}
+
+// newTimedLine parses the timestamp of line. Lines without a timestamp get
+// the server's current time on write, so they sort as the newest.
+func newTimedLine(line, precision string, lineNum int) timedLine {
+	l := timedLine{line: line, precision: precision, lineNum: lineNum, ts: math.MaxInt64}
+	if ts, ok := timestamp(line, precision); ok {
+		l.ts = ts
+	}
+	return l
+}
+
+// window returns the number of the window of length d that l falls into.
+func (l timedLine) window(d time.Duration) int64 {
+	w := l.ts / int64(d)
+	if l.ts < 0 && l.ts%int64(d) != 0 {
+		w--
+	}
+	return w
+}
+
+// annotateLines returns the lines of a failed batch, each preceded by a
+// "# line N" comment giving its line number in the dump. The comments are
+// skipped if the output is imported again.
+func annotateLines(lines []string, lineNums []int) string {
+	var buf bytes.Buffer
+	for n, line := range lines {
+		fmt.Fprintf(&buf, "# line %d\n%s\n", lineNums[n], line)
+	}
+	return buf.String()
+}
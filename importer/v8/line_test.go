@@ -0,0 +1,130 @@
+package v8
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line, key, fields, ts string
+	}{
+		{line: "cpu value=1", key: "cpu", fields: "value=1"},
+		{line: "cpu value=1 10", key: "cpu", fields: "value=1", ts: "10"},
+		{line: `cpu\ load,host=a\ b value=1 10`, key: `cpu\ load,host=a\ b`, fields: "value=1", ts: "10"},
+		{line: `cpu value="a b",x=1i 10`, key: "cpu", fields: `value="a b",x=1i`, ts: "10"},
+		{line: `cpu value="a \" b" 10`, key: "cpu", fields: `value="a \" b"`, ts: "10"},
+		{line: "cpu", key: "cpu"},
+	}
+
+	for _, tt := range tests {
+		key, fields, ts := splitLine(tt.line)
+		if key != tt.key || fields != tt.fields || ts != tt.ts {
+			t.Errorf("%s: unexpected split: key=%q fields=%q ts=%q", tt.line, key, fields, ts)
+		}
+		if tt.fields != "" {
+			if got := joinLine(key, fields, ts); got != tt.line {
+				t.Errorf("%s: unexpected join: %s", tt.line, got)
+			}
+		}
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	got := splitFields(`value=1,desc="a,b",my\ field=2i`)
+	exp := []string{"value=1", `desc="a,b"`, `my\ field=2i`}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected fields: %v", got)
+	}
+
+	if k := fieldKey(exp[2]); k != "my field" {
+		t.Fatalf("unexpected field key: %s", k)
+	}
+	if name := measurementName(`cpu\,load,host=a`); name != "cpu,load" {
+		t.Fatalf("unexpected measurement: %s", name)
+	}
+}
+
+func TestLinesReader(t *testing.T) {
+	for _, lines := range [][]string{
+		nil,
+		{"cpu value=1"},
+		{"cpu value=1", "", "mem free=2 10", "disk used=3"},
+	} {
+		r := newLinesReader(lines)
+		exp := strings.Join(lines, "\n")
+		if r.Len() != len(exp) {
+			t.Fatalf("unexpected length: %d, expected %d", r.Len(), len(exp))
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(b) != exp {
+			t.Fatalf("unexpected content: %q, expected %q", b, exp)
+		}
+	}
+}
+
+func TestAddTag(t *testing.T) {
+	tests := []struct {
+		line, exp string
+	}{
+		{line: "cpu value=1 10", exp: `cpu,run=a\ b value=1 10`},
+		{line: "cpu,host=a value=1", exp: `cpu,host=a,run=a\ b value=1`},
+		{line: "cpu,run=x value=1 10", exp: "cpu,run=x value=1 10"},
+	}
+
+	for _, tt := range tests {
+		if got := addTag(tt.line, "run", "a b"); got != tt.exp {
+			t.Errorf("%s: unexpected line: %s", tt.line, got)
+		}
+	}
+}
+
+func TestSetTags(t *testing.T) {
+	tags := map[string]string{"source": "host A", "dc": "west"}
+	tests := []struct {
+		line      string
+		overwrite bool
+		exp       string
+	}{
+		{line: "cpu value=1 10", exp: `cpu,dc=west,source=host\ A value=1 10`},
+		{line: `my\ cpu,host=a value="x y"`, exp: `my\ cpu,host=a,dc=west,source=host\ A value="x y"`},
+		{line: "cpu,dc=east value=1", exp: `cpu,dc=east,source=host\ A value=1`},
+		{line: "cpu,dc=east value=1", overwrite: true, exp: `cpu,dc=west,source=host\ A value=1`},
+	}
+
+	for _, tt := range tests {
+		if got := setTags(tt.line, tags, tt.overwrite); got != tt.exp {
+			t.Errorf("%s: unexpected line: %s", tt.line, got)
+		}
+	}
+}
+
+func TestEditFields(t *testing.T) {
+	edits := map[string]fieldEdit{"debug": dropField, "load": floatField, "count": intField}
+	tests := []struct {
+		line, exp string
+	}{
+		{line: "cpu load=5i,count=2.7,debug=true 10", exp: "cpu load=5,count=2i 10"},
+		{line: `cpu load=0.5,count=3i,desc="a,debug=1"`, exp: `cpu load=0.5,count=3i,desc="a,debug=1"`},
+		{line: `cpu count="many",load=t`, exp: `cpu count="many",load=t`},
+		{line: "cpu debug=1 10", exp: ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := editFields(tt.line, edits)
+		if got != tt.exp || ok != (tt.exp != "") {
+			t.Errorf("%s: unexpected line: %q %v", tt.line, got, ok)
+		}
+	}
+}
+
+func TestAnnotateLines(t *testing.T) {
+	got := annotateLines([]string{"cpu value=1", "cpu value=2"}, []int{4, 9})
+	if exp := "# line 4\ncpu value=1\n# line 9\ncpu value=2\n"; got != exp {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
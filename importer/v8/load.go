@@ -0,0 +1,82 @@
+package v8
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+)
+
+const (
+	defaultLoadCheckInterval = 10 * time.Second
+	defaultLoadBackoff       = time.Second
+)
+
+// waitForLoad blocks while the server load measured by Config.LoadQuery is
+// above Config.LoadThreshold. The load is measured at most once every
+// Config.LoadCheckInterval, and writes go ahead if it cannot be measured.
+// It returns false if the import was canceled while waiting.
+func (i *Importer) waitForLoad() bool {
+	interval := i.config.LoadCheckInterval
+	if interval <= 0 {
+		interval = defaultLoadCheckInterval
+	}
+	if time.Since(i.lastLoadCheck) < interval {
+		return true
+	}
+	backoff := i.config.LoadBackoff
+	if backoff <= 0 {
+		backoff = defaultLoadBackoff
+	}
+
+	for {
+		i.lastLoadCheck = time.Now()
+		load, err := i.serverLoad()
+		if err != nil {
+			i.logErrorf("error measuring server load, writing anyway: %s\n", err)
+			return true
+		}
+		if load <= i.config.LoadThreshold {
+			return true
+		}
+		i.logf("server load %v is above %v, waiting %s\n", load, i.config.LoadThreshold, backoff)
+		i.loadWaits++
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-i.done:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// serverLoad runs Config.LoadQuery and returns the value of Config.LoadColumn
+// in the first row returned.
+func (i *Importer) serverLoad() (float64, error) {
+	resp, err := i.client.Query(client.Query{Command: i.config.LoadQuery})
+	if err != nil {
+		return 0, err
+	}
+	if err := resp.Error(); err != nil {
+		return 0, err
+	}
+	for _, r := range resp.Results {
+		for _, row := range r.Series {
+			for n, col := range row.Columns {
+				if col != i.config.LoadColumn || len(row.Values) == 0 {
+					continue
+				}
+				switch v := row.Values[0][n].(type) {
+				case json.Number:
+					return v.Float64()
+				case float64:
+					return v, nil
+				}
+				return 0, fmt.Errorf("column %q is not a number", col)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no %q column in result", i.config.LoadColumn)
+}
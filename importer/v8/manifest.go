@@ -0,0 +1,53 @@
+package v8
+
+import (
+	"bufio"
+	"hash/fnv"
+	"os"
+)
+
+// seriesManifest writes every distinct series key it is given to a file, one
+// per line. Only a 64-bit hash of each key is kept in memory.
+type seriesManifest struct {
+	f    *os.File
+	w    *bufio.Writer
+	seen map[uint64]struct{}
+}
+
+// createSeriesManifest creates or truncates the manifest file at path.
+func createSeriesManifest(path string) (*seriesManifest, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &seriesManifest{f: f, w: bufio.NewWriter(f), seen: make(map[uint64]struct{})}, nil
+}
+
+// add writes key to the manifest unless it was added before.
+func (m *seriesManifest) add(key string) error {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	if _, ok := m.seen[sum]; ok {
+		return nil
+	}
+	m.seen[sum] = struct{}{}
+	if _, err := m.w.WriteString(key); err != nil {
+		return err
+	}
+	return m.w.WriteByte('\n')
+}
+
+// Len returns the number of distinct series keys added.
+func (m *seriesManifest) Len() int {
+	return len(m.seen)
+}
+
+// Close flushes and closes the manifest file.
+func (m *seriesManifest) Close() error {
+	if err := m.w.Flush(); err != nil {
+		m.f.Close()
+		return err
+	}
+	return m.f.Close()
+}
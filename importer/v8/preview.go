@@ -0,0 +1,63 @@
+package v8
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultPreviewLines is the number of lines previewed when Preview is given
+// no number.
+const defaultPreviewLines = 10
+
+// Preview applies the configured repairs, checks and rewrites to the first n
+// points of the dump and writes each line to w as it is in the dump and as it
+// would be written, without connecting to a server or writing anything.
+// Lines that would not be written are shown as dropped.
+func (i *Importer) Preview(w io.Writer, n int) error {
+	i.running.Lock()
+	defer i.running.Unlock()
+	i.reset()
+	if i.config.Path == "" && i.config.Reader == nil && len(i.config.SplitPaths) == 0 {
+		return ErrFileRequired
+	}
+	if n <= 0 {
+		n = defaultPreviewLines
+	}
+	i.setupTransforms()
+
+	scanner, closeFile, err := i.openInput()
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	var dml bool
+	for previewed := 0; previewed < n && scanner.Scan(); {
+		i.lineNum++
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# DML"):
+			dml = true
+			continue
+		case strings.HasPrefix(line, "# DDL"):
+			dml = false
+			continue
+		case !dml || strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "":
+			continue
+		case len(i.config.LineRanges) > 0 && !i.inLineRanges(i.lineNum):
+			continue
+		}
+		previewed++
+
+		after, ok := i.transform(line, i.precisionOf(line))
+		if !ok {
+			after = "(dropped)"
+			i.err = nil
+		}
+		if _, err := fmt.Fprintf(w, "line %d:\n  before: %s\n  after:  %s\n", i.lineNum, line, after); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
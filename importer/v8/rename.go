@@ -0,0 +1,61 @@
+package v8
+
+import (
+	"strings"
+
+	"github.com/influxdata/influxdb/pkg/escape"
+)
+
+// renameLine applies Config.RenameMeasurements, RenameTags and RenameFields
+// to line, in that order. Every name is looked up once, so a name produced by
+// one rule is never renamed again by another.
+func (i *Importer) renameLine(line string) string {
+	key, fields, ts := splitLine(line)
+	tags := splitTags(key)
+	if len(tags) == 0 {
+		return line
+	}
+
+	if to, ok := i.config.RenameMeasurements[escape.UnescapeString(tags[0])]; ok {
+		tags[0] = escape.String(to)
+	}
+	if len(i.config.RenameTags) > 0 {
+		for n := 1; n < len(tags); n++ {
+			tags[n] = renameKey(tags[n], i.config.RenameTags)
+		}
+	}
+	if len(i.config.RenameFields) > 0 {
+		pairs := splitFields(fields)
+		for n := range pairs {
+			pairs[n] = renameKey(pairs[n], i.config.RenameFields)
+		}
+		fields = strings.Join(pairs, ",")
+	}
+	return joinLine(strings.Join(tags, ","), fields, ts)
+}
+
+// renameKey renames the key of a key=value pair if renames has it.
+func renameKey(pair string, renames map[string]string) string {
+	eq := scanTo(pair, 0, '=', false)
+	if to, ok := renames[escape.UnescapeString(pair[:eq])]; ok {
+		return escape.String(to) + pair[eq:]
+	}
+	return pair
+}
+
+// lowerNames lowercases the measurement name and tag keys of line, leaving
+// tag values and fields as they are. Escaped characters are not letters, so
+// lowercasing the escaped names keeps them escaped.
+func lowerNames(line string) string {
+	key, fields, ts := splitLine(line)
+	tags := splitTags(key)
+	if len(tags) == 0 {
+		return line
+	}
+	tags[0] = strings.ToLower(tags[0])
+	for n := 1; n < len(tags); n++ {
+		eq := scanTo(tags[n], 0, '=', false)
+		tags[n] = strings.ToLower(tags[n][:eq]) + tags[n][eq:]
+	}
+	return joinLine(strings.Join(tags, ","), fields, ts)
+}
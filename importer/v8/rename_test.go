@@ -0,0 +1,47 @@
+package v8
+
+import "testing"
+
+func TestImporter_renameLine(t *testing.T) {
+	// Overlapping rules must not chain, whatever order the maps iterate in.
+	i := NewImporter(Config{
+		RenameMeasurements: map[string]string{"cpu": "mem", "mem": "disk"},
+		RenameTags:         map[string]string{"host": "server", "server": "node", "region": "data center"},
+		RenameFields:       map[string]string{"value": "v", "v": "w"},
+	})
+
+	for n := 0; n < 100; n++ {
+		line := i.renameLine(`cpu,host=a,server=b,region=west value=1,v=2,desc="x,v=3" 1464026335`)
+		if exp := `mem,server=a,node=b,data\ center=west v=1,w=2,desc="x,v=3" 1464026335`; line != exp {
+			t.Fatalf("unexpected line: got %q, expected %q", line, exp)
+		}
+	}
+
+	if line := i.renameLine(`my\ cpu load=1`); line != `my\ cpu load=1` {
+		t.Fatalf("unexpected line: %q", line)
+	}
+
+	// Escaped names are renamed by their unescaped name.
+	i = NewImporter(Config{RenameMeasurements: map[string]string{"old cpu,x": "cpu", "mem": "new mem,y"}})
+	if line := i.renameLine(`old\ cpu\,x,host=a value=1 1464026335`); line != `cpu,host=a value=1 1464026335` {
+		t.Fatalf("unexpected line: %q", line)
+	}
+	if line := i.renameLine(`mem free=1`); line != `new\ mem\,y free=1` {
+		t.Fatalf("unexpected line: %q", line)
+	}
+}
+
+func TestLowerNames(t *testing.T) {
+	tests := []struct {
+		line, exp string
+	}{
+		{line: `CPU,Host=Server1,REGION=West value=1 10`, exp: `cpu,host=Server1,region=West value=1 10`},
+		{line: `My\ CPU,Data\ Center=East\ 1,Rack\=A=B Load=1,Desc="Mixed Case"`, exp: `my\ cpu,data\ center=East\ 1,rack\=a=B Load=1,Desc="Mixed Case"`},
+		{line: `Mem\,Swap free=1`, exp: `mem\,swap free=1`},
+	}
+	for _, tt := range tests {
+		if got := lowerNames(tt.line); got != tt.exp {
+			t.Errorf("%s: got %q, expected %q", tt.line, got, tt.exp)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package v8
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// repair fixes a common line protocol problem found in old dumps.
+type repair struct {
+	name    string
+	enabled func(c *Config) bool
+
+	// fix returns the repaired line, or an empty string to drop the line.
+	fix func(line, precision string) string
+}
+
+// repairs are applied to every line in this order.
+var repairs = []repair{
+	{
+		name:    "trim whitespace",
+		enabled: func(c *Config) bool { return c.Repair || c.TrimLines },
+		fix:     func(line, _ string) string { return trimLine(line) },
+	},
+	{
+		name:    "escape tag values",
+		enabled: func(c *Config) bool { return c.Repair || c.EscapeTagValues },
+		fix:     func(line, _ string) string { return escapeTagValues(line) },
+	},
+	{
+		name:    "sort tags",
+		enabled: func(c *Config) bool { return c.Repair || c.SortTags },
+		fix:     func(line, _ string) string { return sortTags(line) },
+	},
+	{
+		name:    "drop lines without fields",
+		enabled: func(c *Config) bool { return c.Repair || c.DropEmptyFieldLines },
+		fix:     func(line, _ string) string { return dropEmptyFieldLine(line) },
+	},
+	{
+		name:    "fix timestamp precision",
+		enabled: func(c *Config) bool { return c.Repair || c.FixPrecision },
+		fix:     fixPrecision,
+	},
+}
+
+// trimLine removes leading and trailing whitespace, such as the carriage
+// return left behind by CRLF line endings.
+func trimLine(line string) string {
+	return strings.TrimSpace(line)
+}
+
+// escapeTagValues escapes equals signs in tag values, which the line protocol
+// parser would otherwise mistake for the start of a new tag value.
+func escapeTagValues(line string) string {
+	key, fields, ts := splitLine(line)
+	tags := splitTags(key)
+	for n := 1; n < len(tags); n++ {
+		eq := scanTo(tags[n], 0, '=', false)
+		if eq >= len(tags[n]) {
+			continue
+		}
+
+		var buf []byte
+		value := tags[n][eq+1:]
+		for j := 0; j < len(value); j++ {
+			switch value[j] {
+			case '\\':
+				buf = append(buf, value[j])
+				if j+1 < len(value) {
+					j++
+					buf = append(buf, value[j])
+				}
+			case '=':
+				buf = append(buf, '\\', '=')
+			default:
+				buf = append(buf, value[j])
+			}
+		}
+		tags[n] = tags[n][:eq+1] + string(buf)
+	}
+	return joinLine(strings.Join(tags, ","), fields, ts)
+}
+
+// sortTags sorts the tags of a line by key.
+func sortTags(line string) string {
+	key, fields, ts := splitLine(line)
+	tags := splitTags(key)
+	if len(tags) < 3 {
+		return line
+	}
+	sort.Strings(tags[1:])
+	return joinLine(strings.Join(tags, ","), fields, ts)
+}
+
+// dropEmptyFieldLine returns an empty string if line has no fields.
+func dropEmptyFieldLine(line string) string {
+	if _, fields, _ := splitLine(line); fields == "" {
+		return ""
+	}
+	return line
+}
+
+// fixPrecision scales timestamps that were written in seconds, milliseconds or
+// microseconds up to nanoseconds when importing with nanosecond precision.
+// The unit is guessed from the number of digits, which is reliable for
+// timestamps between 2001 and 2286.
+func fixPrecision(line, precision string) string {
+	if precision != "" && precision != "n" && precision != "ns" {
+		return line
+	}
+
+	key, fields, ts := splitLine(line)
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || n <= 0 {
+		return line
+	}
+	switch len(ts) {
+	case 10:
+		n *= 1e9
+	case 13:
+		n *= 1e6
+	case 16:
+		n *= 1e3
+	default:
+		return line
+	}
+	return joinLine(key, fields, strconv.FormatInt(n, 10))
+}
+
+// repairLine applies the enabled repairs to a line with timestamps in the
+// given precision, counting the lines each one changed. It returns false if
+// the line was dropped.
+func (i *Importer) repairLine(line, precision string) (string, bool) {
+	for n, r := range repairs {
+		if !r.enabled(&i.config) {
+			continue
+		}
+		fixed := r.fix(line, precision)
+		if fixed != line {
+			i.repaired[n]++
+		}
+		if fixed == "" {
+			return "", false
+		}
+		line = fixed
+	}
+	return line, true
+}
@@ -0,0 +1,49 @@
+package v8
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRepairs(t *testing.T) {
+	tests := []struct {
+		name      string
+		fix       func(line, precision string) string
+		precision string
+		line      string
+		exp       string
+	}{
+		{name: "trim", fix: func(l, _ string) string { return trimLine(l) }, line: "cpu value=1 10\r", exp: "cpu value=1 10"},
+		{name: "escape", fix: func(l, _ string) string { return escapeTagValues(l) }, line: `cpu,host=a=b,dc=c\=d value=1 10`, exp: `cpu,host=a\=b,dc=c\=d value=1 10`},
+		{name: "escape none", fix: func(l, _ string) string { return escapeTagValues(l) }, line: "cpu value=1 10", exp: "cpu value=1 10"},
+		{name: "sort", fix: func(l, _ string) string { return sortTags(l) }, line: `cpu,region=west,host=a\,b value=1 10`, exp: `cpu,host=a\,b,region=west value=1 10`},
+		{name: "drop", fix: func(l, _ string) string { return dropEmptyFieldLine(l) }, line: "cpu,host=a", exp: ""},
+		{name: "keep", fix: func(l, _ string) string { return dropEmptyFieldLine(l) }, line: "cpu value=1", exp: "cpu value=1"},
+		{name: "seconds", fix: fixPrecision, line: "cpu value=1 1464026335", exp: "cpu value=1 1464026335000000000"},
+		{name: "milliseconds", fix: fixPrecision, precision: "ns", line: "cpu value=1 1464026335123", exp: "cpu value=1 1464026335123000000"},
+		{name: "microseconds", fix: fixPrecision, line: "cpu value=1 1464026335123456", exp: "cpu value=1 1464026335123456000"},
+		{name: "nanoseconds", fix: fixPrecision, line: "cpu value=1 1464026335123456789", exp: "cpu value=1 1464026335123456789"},
+		{name: "other precision", fix: fixPrecision, precision: "s", line: "cpu value=1 1464026335", exp: "cpu value=1 1464026335"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.fix(tt.line, tt.precision); got != tt.exp {
+			t.Errorf("%s: unexpected line: got %q, expected %q", tt.name, got, tt.exp)
+		}
+	}
+}
+
+func TestImporter_repairLine(t *testing.T) {
+	i := NewImporter(Config{Repair: true})
+	i.repaired = make([]int, len(repairs))
+
+	if line, ok := i.repairLine("cpu,region=west,host=a=b value=1 1464026335\r", ""); !ok || line != `cpu,host=a\=b,region=west value=1 1464026335000000000` {
+		t.Fatalf("unexpected line: %q", line)
+	}
+	if _, ok := i.repairLine("cpu,host=a\r", ""); ok {
+		t.Fatal("expected line to be dropped")
+	}
+	if exp := []int{2, 1, 1, 1, 1}; !reflect.DeepEqual(i.repaired, exp) {
+		t.Fatalf("unexpected repair counts: %v", i.repaired)
+	}
+}
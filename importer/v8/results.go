@@ -0,0 +1,39 @@
+package v8
+
+import (
+	"time"
+)
+
+// BatchResult is the outcome of writing a single batch, as emitted to
+// Config.ResultsWriter.
+type BatchResult struct {
+	Batch           int           `json:"batch"`
+	Database        string        `json:"database"`
+	RetentionPolicy string        `json:"retention_policy"`
+	FirstLine       int           `json:"first_line"`
+	LastLine        int           `json:"last_line"`
+	Points          int           `json:"points"`
+	Duration        time.Duration `json:"duration"`
+	Success         bool          `json:"success"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// writeResult emits the result of writing b to Config.ResultsWriter.
+func (i *Importer) writeResult(b *batch, d time.Duration, err error) {
+	r := BatchResult{
+		Batch:           b.id,
+		Database:        b.database,
+		RetentionPolicy: b.retentionPolicy,
+		FirstLine:       b.lineNums[0],
+		LastLine:        b.lineNums[len(b.lineNums)-1],
+		Points:          len(b.lines),
+		Duration:        d,
+		Success:         err == nil,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if e := i.results.Encode(r); e != nil {
+		i.logln("error writing batch result: ", e)
+	}
+}
@@ -0,0 +1,87 @@
+package v8
+
+import (
+	"bufio"
+	"time"
+)
+
+// lineScanner reads a dump line by line, like *bufio.Scanner.
+type lineScanner interface {
+	Scan() bool
+	Text() string
+	Err() error
+}
+
+// flushScanner scans lines in a goroutine so that, while waiting for the
+// next line of a slow stream, flush can be called whenever deadline has
+// passed. flush must move the deadline forward. Scan stops early once
+// canceled is closed.
+type flushScanner struct {
+	lines    chan string
+	stopped  chan struct{}
+	canceled <-chan struct{}
+	deadline func() time.Time
+	flush    func()
+
+	text string
+	eof  bool  // lines was closed
+	err  error // of the underlying scanner, set before lines is closed
+}
+
+func newFlushScanner(s *bufio.Scanner, deadline func() time.Time, flush func(), canceled <-chan struct{}) *flushScanner {
+	fs := &flushScanner{
+		lines:    make(chan string),
+		stopped:  make(chan struct{}),
+		canceled: canceled,
+		deadline: deadline,
+		flush:    flush,
+	}
+	go func() {
+		defer close(fs.lines)
+		for s.Scan() {
+			select {
+			case fs.lines <- s.Text():
+			case <-fs.stopped:
+				return
+			}
+		}
+		fs.err = s.Err()
+	}()
+	return fs
+}
+
+func (fs *flushScanner) Scan() bool {
+	for {
+		wait := fs.deadline().Sub(time.Now())
+		if wait <= 0 {
+			fs.flush()
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case line, ok := <-fs.lines:
+			timer.Stop()
+			fs.text, fs.eof = line, !ok
+			return ok
+		case <-fs.canceled:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+func (fs *flushScanner) Text() string { return fs.text }
+
+func (fs *flushScanner) Err() error {
+	if !fs.eof {
+		return nil
+	}
+	return fs.err
+}
+
+// stop ends the scanning goroutine. One blocked reading the dump ends once
+// the dump is closed or the read returns.
+func (fs *flushScanner) stop() {
+	close(fs.stopped)
+}
@@ -0,0 +1,152 @@
+package v8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/influxql"
+)
+
+// Sink receives batches of line protocol in place of an InfluxDB server.
+type Sink interface {
+	// WriteBatch writes lines destined for a database and retention policy.
+	WriteBatch(database, retentionPolicy string, lines []string) error
+
+	// Close flushes any buffered lines and releases the sink.
+	Close() error
+}
+
+// Client is the part of *client.Client used by the importer.
+type Client interface {
+	Ping() (time.Duration, string, error)
+	Addr() string
+	Query(q client.Query) (*client.Response, error)
+	WriteLineProtocol(data, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error)
+	WriteLineProtocolReader(r io.Reader, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error)
+	WriteBucketLineProtocolReader(r io.Reader, org, bucket, precision string) (*client.Response, error)
+}
+
+// DDLSink is implemented by sinks that also receive the DDL statements of a dump.
+type DDLSink interface {
+	Sink
+
+	// ExecuteDDL receives a single DDL statement.
+	ExecuteDDL(command string) error
+}
+
+// newKafkaSink creates the sink for Config.KafkaBrokers. It is nil unless the
+// importer is built with the kafka build tag.
+var newKafkaSink func(brokers []string, topic string) (Sink, error)
+
+// DatabaseFileSink is a Sink that writes a separate dump file for every
+// destination database, splitting a dump spanning several databases into
+// dumps that can be imported one at a time.
+type DatabaseFileSink struct {
+	dir   string
+	ddl   []string
+	files map[string]*databaseFile
+}
+
+// databaseFile is an open dump file for a single database.
+type databaseFile struct {
+	f               *os.File
+	w               *bufio.Writer
+	retentionPolicy string
+}
+
+// NewDatabaseFileSink returns a sink that writes a dump file named after each
+// database into dir.
+func NewDatabaseFileSink(dir string) *DatabaseFileSink {
+	return &DatabaseFileSink{
+		dir:   dir,
+		files: make(map[string]*databaseFile),
+	}
+}
+
+// ExecuteDDL records a DDL statement so that it can be added to the header of
+// the dump file of the database it applies to.
+func (s *DatabaseFileSink) ExecuteDDL(command string) error {
+	s.ddl = append(s.ddl, command)
+	return nil
+}
+
+// WriteBatch appends lines to the dump file of database, creating the file
+// with a DDL header the first time the database is seen.
+func (s *DatabaseFileSink) WriteBatch(database, retentionPolicy string, lines []string) error {
+	df, ok := s.files[database]
+	if !ok {
+		var err error
+		if df, err = s.create(database); err != nil {
+			return err
+		}
+		s.files[database] = df
+	}
+
+	if df.retentionPolicy != retentionPolicy {
+		if _, err := fmt.Fprintf(df.w, "# CONTEXT-RETENTION-POLICY:%s\n", retentionPolicy); err != nil {
+			return err
+		}
+		df.retentionPolicy = retentionPolicy
+	}
+	for _, line := range lines {
+		if _, err := df.w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create creates the dump file for database and writes its header.
+func (s *DatabaseFileSink) create(database string) (*databaseFile, error) {
+	f, err := os.Create(filepath.Join(s.dir, url.PathEscape(database)))
+	if err != nil {
+		return nil, err
+	}
+	df := &databaseFile{f: f, w: bufio.NewWriter(f)}
+
+	// Only carry over the DDL statements that apply to this database, and
+	// make sure the database gets created.
+	var ddl []string
+	var created bool
+	for _, command := range s.ddl {
+		if db, ok := ddlDatabase(command); !ok || db != database {
+			continue
+		}
+		if createDatabaseRegexp.MatchString(command) {
+			created = true
+		}
+		ddl = append(ddl, command)
+	}
+	if !created {
+		ddl = append([]string{"CREATE DATABASE " + influxql.QuoteIdent(database)}, ddl...)
+	}
+
+	fmt.Fprintln(df.w, "# DDL")
+	for _, command := range ddl {
+		fmt.Fprintln(df.w, command)
+	}
+	fmt.Fprintln(df.w)
+	fmt.Fprintln(df.w, "# DML")
+	fmt.Fprintf(df.w, "# CONTEXT-DATABASE:%s\n", database)
+	return df, nil
+}
+
+// Close flushes and closes every dump file.
+func (s *DatabaseFileSink) Close() error {
+	var err error
+	for _, df := range s.files {
+		if e := df.w.Flush(); e != nil && err == nil {
+			err = e
+		}
+		if e := df.f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package zstd_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/influxdata/influxdb/pkg/zstd"
+)
+
+// frame is 2000 lines that repeat one another, compressed by the zstd
+// command at level 19.
+const frame = "KLUv/WRQwnUCALQCY3B1LGhvc3Q9c2VydmVyMCB2YWx1ZT0wCjExMjIwMzQwMTIzNDAxMjM0ChKgEOjr/waw42Ix1kE9HQyCYCAIBsEHOsCNMiiyizubAoyI+ik="
+
+// lines returns the text compressed in frame.
+func lines() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&buf, "cpu,host=server%d value=%d\n", i%3, i%5)
+	}
+	return buf.Bytes()
+}
+
+func MustDecode(t *testing.T, s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReader(t *testing.T) {
+	b, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(MustDecode(t, frame))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, lines()) {
+		t.Fatalf("unexpected text: %q", b)
+	}
+}
+
+func TestReader_Frames(t *testing.T) {
+	// Frames written one after another read as their texts joined
+	data := MustDecode(t, frame)
+	b, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(append(data, data...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := append(lines(), lines()...); !bytes.Equal(b, exp) {
+		t.Fatalf("unexpected text: %q", b)
+	}
+}
+
+func TestReader_Truncated(t *testing.T) {
+	data := MustDecode(t, frame)
+	if _, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(data[:len(data)-10]))); err == nil {
+		t.Fatal("expected error")
+	}
+}